@@ -51,3 +51,6 @@ echo "Completed shadowed-variable check .."
 
 # Run golang tests
 go test ./...
+
+# Run them again on a 32-bit platform, as we build releases for 386 too
+GOARCH=386 go test ./...
@@ -0,0 +1,309 @@
+// Package bytecode contains the container format for compiled programs.
+//
+// Historically compiled programs were a bare dump of the bytes to load
+// into RAM at address zero.  The container wraps those bytes with enough
+// information to identify, and validate, a program:
+//
+//     magic        4 bytes   "GOVM"
+//     version      2 bytes   the ISA version the program targets
+//     entry        2 bytes   the address at which execution begins
+//     sections     2 bytes   the number of sections which follow
+//
+// Each section then consists of:
+//
+//     type         1 byte    code, data, or symbols
+//     address      2 bytes   the address at which the section is loaded
+//     length       4 bytes   the number of bytes of content
+//     content      ...
+//
+// Finally a CRC32 (IEEE) checksum of all the preceding bytes is stored.
+//
+// All numbers are stored little-endian, as they are within our bytecode.
+// The optional symbol table contains the name and address of each label,
+// which is useful for tools, but isn't required to run the program.
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Magic is the value every container begins with.
+const Magic = "GOVM"
+
+// Version is the version of the instruction-set our programs target.
+//
+// Version 2 made arithmetic wrap around, rather than saturate, and added
+// new instructions, so programs compiled for version 1 are rejected and
+// must be recompiled.
+const Version = 2
+
+// MemorySize is the number of bytes of RAM our programs are loaded into.
+const MemorySize = 0xFFFF
+
+// headerSize is the size of the fixed header.
+const headerSize = 10
+
+// SectionType describes the contents of a section.
+type SectionType byte
+
+const (
+	// Code sections hold instructions.
+	Code SectionType = 1
+
+	// Data sections hold data used by the program.
+	Data SectionType = 2
+
+	// Symbols sections hold the symbol table.
+	Symbols SectionType = 3
+)
+
+// String converts the given SectionType to a string.
+func (t SectionType) String() string {
+	switch t {
+	case Code:
+		return "code"
+	case Data:
+		return "data"
+	case Symbols:
+		return "symbols"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
+
+// Section holds bytes which are loaded into RAM at the given address.
+type Section struct {
+	// Type is the type of the section, which is Code or Data.
+	Type SectionType
+
+	// Address is the address at which the section is loaded.
+	Address int
+
+	// Data holds the contents of the section.
+	Data []byte
+}
+
+// Symbol records the address of a label.
+type Symbol struct {
+	// Name is the name of the label.
+	Name string
+
+	// Address is the address of the label.
+	Address int
+}
+
+// Program is a compiled program.
+type Program struct {
+	// Version is the ISA version the program targets.
+	Version int
+
+	// Entry is the address at which execution begins.
+	Entry int
+
+	// Sections holds the code and data of the program.
+	Sections []Section
+
+	// Symbols holds the optional symbol table.
+	Symbols []Symbol
+}
+
+// New returns a program containing the given code, which is loaded
+// at address zero, and executed from there.
+func New(code []byte) *Program {
+	return &Program{
+		Version:  Version,
+		Sections: []Section{{Type: Code, Address: 0, Data: code}},
+	}
+}
+
+// AddSymbol adds a symbol to the program's symbol table.
+func (p *Program) AddSymbol(name string, address int) {
+	p.Symbols = append(p.Symbols, Symbol{Name: name, Address: address})
+}
+
+// IsContainer returns true if the given data begins with our magic
+// value, rather than being a bare program.
+func IsContainer(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// Load returns the program held in the given data, which may be either a
+// container or a bare program from before containers existed.
+func Load(data []byte) (*Program, error) {
+	if IsContainer(data) {
+		return Decode(data)
+	}
+
+	if len(data) >= MemorySize {
+		return nil, fmt.Errorf("program too large for RAM %d", len(data))
+	}
+	return New(data), nil
+}
+
+// Encode returns the program as a container.
+func (p *Program) Encode() []byte {
+	var out bytes.Buffer
+	sections := p.Sections
+	if len(p.Symbols) > 0 {
+		sections = append(append([]Section{}, sections...), Section{Type: Symbols, Data: p.encodeSymbols()})
+	}
+
+	out.WriteString(Magic)
+	binary.Write(&out, binary.LittleEndian, uint16(p.Version))
+	binary.Write(&out, binary.LittleEndian, uint16(p.Entry))
+	binary.Write(&out, binary.LittleEndian, uint16(len(sections)))
+
+	for _, s := range sections {
+		out.WriteByte(byte(s.Type))
+		binary.Write(&out, binary.LittleEndian, uint16(s.Address))
+		binary.Write(&out, binary.LittleEndian, uint32(len(s.Data)))
+		out.Write(s.Data)
+	}
+
+	binary.Write(&out, binary.LittleEndian, crc32.ChecksumIEEE(out.Bytes()))
+	return out.Bytes()
+}
+
+// encodeSymbols returns the contents of the symbol table section.
+func (p *Program) encodeSymbols() []byte {
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, uint16(len(p.Symbols)))
+	for _, sym := range p.Symbols {
+		name := sym.Name
+		if len(name) > 0xFF {
+			name = name[:0xFF]
+		}
+		binary.Write(&out, binary.LittleEndian, uint16(sym.Address))
+		out.WriteByte(byte(len(name)))
+		out.WriteString(name)
+	}
+	return out.Bytes()
+}
+
+// Decode parses, and validates, the given container.
+func Decode(data []byte) (*Program, error) {
+	if !IsContainer(data) {
+		return nil, fmt.Errorf("invalid bytecode: missing magic value")
+	}
+	if len(data) < headerSize+4 {
+		return nil, fmt.Errorf("invalid bytecode: truncated header")
+	}
+
+	// Validate the checksum before looking at anything else.
+	body := data[:len(data)-4]
+	expected := binary.LittleEndian.Uint32(data[len(data)-4:])
+	if actual := crc32.ChecksumIEEE(body); actual != expected {
+		return nil, fmt.Errorf("invalid bytecode: checksum mismatch, expected %08X got %08X", expected, actual)
+	}
+
+	p := &Program{
+		Version: int(binary.LittleEndian.Uint16(body[4:])),
+		Entry:   int(binary.LittleEndian.Uint16(body[6:])),
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("invalid bytecode: unsupported ISA version %d, expected %d", p.Version, Version)
+	}
+
+	count := int(binary.LittleEndian.Uint16(body[8:]))
+	offset := headerSize
+	for i := 0; i < count; i++ {
+		if offset+7 > len(body) {
+			return nil, fmt.Errorf("invalid bytecode: truncated section %d", i)
+		}
+		t := SectionType(body[offset])
+		addr := int(binary.LittleEndian.Uint16(body[offset+1:]))
+		length := int(binary.LittleEndian.Uint32(body[offset+3:]))
+		offset += 7
+
+		if length > len(body)-offset {
+			return nil, fmt.Errorf("invalid bytecode: truncated section %d", i)
+		}
+		content := body[offset : offset+length]
+		offset += length
+
+		switch t {
+		case Code, Data:
+			if addr+length > MemorySize {
+				return nil, fmt.Errorf("invalid bytecode: %s section at %04X of %d bytes doesn't fit in RAM", t, addr, length)
+			}
+			p.Sections = append(p.Sections, Section{Type: t, Address: addr, Data: content})
+		case Symbols:
+			err := p.decodeSymbols(content)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid bytecode: unknown section type %d", byte(t))
+		}
+	}
+	if offset != len(body) {
+		return nil, fmt.Errorf("invalid bytecode: %d unexpected bytes after the sections", len(body)-offset)
+	}
+
+	return p, p.validate()
+}
+
+// decodeSymbols parses the contents of the symbol table section.
+func (p *Program) decodeSymbols(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("invalid bytecode: truncated symbol table")
+	}
+	count := int(binary.LittleEndian.Uint16(data))
+	offset := 2
+	for i := 0; i < count; i++ {
+		if offset+3 > len(data) || offset+3+int(data[offset+2]) > len(data) {
+			return fmt.Errorf("invalid bytecode: truncated symbol table")
+		}
+		addr := int(binary.LittleEndian.Uint16(data[offset:]))
+		length := int(data[offset+2])
+		p.AddSymbol(string(data[offset+3:offset+3+length]), addr)
+		offset += 3 + length
+	}
+	return nil
+}
+
+// validate ensures the sections don't overlap, and that the entry-point
+// is within the code.
+func (p *Program) validate() error {
+	for i, a := range p.Sections {
+		for _, b := range p.Sections[i+1:] {
+			if a.Address < b.Address+len(b.Data) && b.Address < a.Address+len(a.Data) {
+				return fmt.Errorf("invalid bytecode: sections at %04X and %04X overlap", a.Address, b.Address)
+			}
+		}
+	}
+
+	code := false
+	for _, s := range p.Sections {
+		if s.Type != Code || len(s.Data) == 0 {
+			continue
+		}
+		code = true
+		if p.Entry >= s.Address && p.Entry < s.Address+len(s.Data) {
+			return nil
+		}
+	}
+	if !code && p.Entry == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid bytecode: entry point %04X is outside the code", p.Entry)
+}
+
+// Image returns the contents of RAM once the program has been loaded,
+// up to the end of the last section.
+func (p *Program) Image() []byte {
+	size := 0
+	for _, s := range p.Sections {
+		if end := s.Address + len(s.Data); end > size {
+			size = end
+		}
+	}
+
+	image := make([]byte, size)
+	for _, s := range p.Sections {
+		copy(image[s.Address:], s.Data)
+	}
+	return image
+}
@@ -0,0 +1,129 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRoundTrip ensures programs survive being encoded and decoded.
+func TestRoundTrip(t *testing.T) {
+	prog := &Program{
+		Version: Version,
+		Entry:   0x10,
+		Sections: []Section{
+			{Type: Data, Address: 0, Data: []byte{1, 2, 3}},
+			{Type: Code, Address: 0x10, Data: []byte{0x50, 0x00}},
+		},
+	}
+	prog.AddSymbol("main", 0x10)
+	prog.AddSymbol("table", 0)
+
+	out, err := Decode(prog.Encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(prog, out) {
+		t.Fatalf("round-trip failed:\n%v\n%v", prog, out)
+	}
+
+	image := out.Image()
+	expected := make([]byte, 0x12)
+	copy(expected, []byte{1, 2, 3})
+	copy(expected[0x10:], []byte{0x50, 0x00})
+	if !reflect.DeepEqual(image, expected) {
+		t.Fatalf("unexpected image % X", image)
+	}
+}
+
+// TestLegacy ensures bare programs are still accepted.
+func TestLegacy(t *testing.T) {
+	prog, err := Load([]byte{0x50, 0x00})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if prog.Entry != 0 || len(prog.Sections) != 1 || !reflect.DeepEqual(prog.Image(), []byte{0x50, 0x00}) {
+		t.Fatalf("unexpected program %v", prog)
+	}
+
+	_, err = Load(make([]byte, MemorySize))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestInvalid ensures bogus containers are rejected.
+func TestInvalid(t *testing.T) {
+
+	// seal updates the checksum of the given container.
+	seal := func(data []byte) []byte {
+		body := data[:len(data)-4]
+		binary.LittleEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(body))
+		return data
+	}
+
+	valid := New([]byte{0x50, 0x00}).Encode()
+
+	tests := []struct {
+		name  string
+		data  func() []byte
+		error string
+	}{
+		{"truncated", func() []byte { return []byte(Magic) }, "truncated header"},
+		{"checksum", func() []byte {
+			d := append([]byte{}, valid...)
+			d[len(d)-6] ^= 0xFF
+			return d
+		}, "checksum mismatch"},
+		{"version", func() []byte {
+			d := append([]byte{}, valid...)
+			d[4] = 9
+			return seal(d)
+		}, "unsupported ISA version 9"},
+		{"old version", func() []byte {
+			d := append([]byte{}, valid...)
+			d[4] = 1
+			return seal(d)
+		}, "unsupported ISA version 1, expected 2"},
+		{"entry", func() []byte {
+			d := append([]byte{}, valid...)
+			d[6] = 2
+			return seal(d)
+		}, "entry point 0002 is outside the code"},
+		{"section type", func() []byte {
+			d := append([]byte{}, valid...)
+			d[headerSize] = 9
+			return seal(d)
+		}, "unknown section type 9"},
+		{"section length", func() []byte {
+			d := append([]byte{}, valid...)
+			d[headerSize+3] = 9
+			return seal(d)
+		}, "truncated section 0"},
+		{"trailing", func() []byte {
+			d := append([]byte{}, valid[:len(valid)-4]...)
+			d = append(d, 0, 0, 0, 0, 0)
+			return seal(d)
+		}, "1 unexpected bytes"},
+		{"overflow", func() []byte {
+			p := &Program{Version: Version, Sections: []Section{{Type: Code, Address: 0xFFF0, Data: make([]byte, 0x20)}}}
+			return p.Encode()
+		}, "doesn't fit in RAM"},
+		{"overlap", func() []byte {
+			p := &Program{Version: Version, Sections: []Section{
+				{Type: Code, Address: 0, Data: make([]byte, 4)},
+				{Type: Data, Address: 2, Data: make([]byte, 4)},
+			}}
+			return p.Encode()
+		}, "sections at 0000 and 0002 overlap"},
+	}
+
+	for _, test := range tests {
+		_, err := Load(test.data())
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected error '%s', got '%v'", test.name, test.error, err)
+		}
+	}
+}
@@ -10,10 +10,26 @@ import (
 
 	"github.com/google/subcommands"
 	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/debuginfo"
 	"github.com/skx/go.vm/lexer"
 )
 
 type compileCmd struct {
+	// Should we write debug information?
+	debug bool
+
+	// Should we write a relocatable object, rather than bytecode?
+	object bool
+
+	// Directories to search for included files.
+	includes pathList
+
+	// File to write a listing to.
+	listing string
+
+	// The number of bits in the integers of the machine which will
+	// execute the program.
+	wordSize wordSize
 }
 
 //
@@ -24,13 +40,34 @@ func (*compileCmd) Synopsis() string { return "Compile a simple.vm program." }
 func (*compileCmd) Usage() string {
 	return `compile :
   Compile the given input file to a series of bytecodes.
+
+  If -debug is given then debug information is written alongside the
+  bytecode, allowing runtime errors to be mapped back to source lines.
+
+  Files named by '.include' are found relative to the file including
+  them, or within any directory given via -I.
+
+  If -object is given then a relocatable object is written to a .obj file,
+  rather than bytecode.  Objects may be combined via the 'link' command.
+
+  If -listing is given then a listing of the program, showing the address
+  and bytes generated for each source line, is written to the named file.
+
+  Programs using numbers which don't fit in 16 bits must be compiled with
+  the -word-size they'll be executed with.
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *compileCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.debug, "debug", false, "Write debug information to a .dbg file.")
+	f.BoolVar(&p.object, "object", false, "Write a relocatable object to a .obj file.")
+	f.Var(&p.includes, "I", "Add a directory to search for included files.")
+	f.StringVar(&p.listing, "listing", "", "Write a listing of the compiled program to the given file.")
+	p.wordSize = 16
+	f.Var(&p.wordSize, "word-size", "The number of bits in the integers of the machine which will execute the program: 16, 32, or 64.")
 }
 
 //
@@ -55,13 +92,55 @@ func (p *compileCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 
 		// Compile it
 		e := compiler.New(l)
-		e.Compile()
+		e.SetFilename(file)
+		e.SetRelocatable(p.object)
+		e.SetWordSize(int(p.wordSize))
+		for _, dir := range p.includes {
+			e.AddIncludePath(dir)
+		}
+		err = e.Compile()
+		showDiagnostics(e.Diagnostics())
+		if err != nil {
+			return subcommands.ExitFailure
+		}
+
+		// Write the listing, if we should.
+		if p.listing != "" {
+			err = e.WriteListing(p.listing)
+			if err != nil {
+				fmt.Printf("%s\n", err.Error())
+				return subcommands.ExitFailure
+			}
+		}
 
 		// Write it out - remove the suffix from the file
 		name := strings.TrimSuffix(file, filepath.Ext(file))
 
+		// If we're writing an object then we're done.
+		if p.object {
+			err = e.Object().Save(name + ".obj")
+			if err != nil {
+				fmt.Printf("Error writing object: %s\n", err.Error())
+				return subcommands.ExitFailure
+			}
+			continue
+		}
+
 		// Add a .raw suffix to the file.
-		e.Write(name + ".raw")
+		err = e.Write(name + ".raw")
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+
+		// Write the debug information, if we should.
+		if p.debug {
+			err = e.DebugInfo().Save(debuginfo.Filename(name + ".raw"))
+			if err != nil {
+				fmt.Printf("Error writing debug information: %s\n", err.Error())
+				return subcommands.ExitFailure
+			}
+		}
 	}
 	return subcommands.ExitSuccess
 }
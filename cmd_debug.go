@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/debugger"
+	"github.com/skx/go.vm/debuginfo"
+	"github.com/skx/go.vm/lexer"
+)
+
+type debugCmd struct {
+	// Directories to search for included files.
+	includes pathList
+
+	// How the machine behaves.
+	mode modeFlags
+}
+
+//
+// Glue
+//
+func (*debugCmd) Name() string     { return "debug" }
+func (*debugCmd) Synopsis() string { return "Debug a program interactively." }
+func (*debugCmd) Usage() string {
+	return `debug :
+  Load the given program, and allow it to be debugged interactively.
+
+  The program may be source, which is compiled first, or bytecode.  If
+  debug information is present alongside bytecode, as written by
+  'compile -debug', then source lines and labels are available.
+
+  Enter 'help' at the prompt to see the available commands.
+`
+}
+
+//
+// Flag setup
+//
+func (p *debugCmd) SetFlags(f *flag.FlagSet) {
+	f.Var(&p.includes, "I", "Add a directory to search for included files.")
+	p.mode.register(f)
+}
+
+//
+// Entry-point.
+//
+func (p *debugCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if len(f.Args()) != 1 {
+		fmt.Printf("Usage: debug file.in|file.raw\n")
+		return subcommands.ExitFailure
+	}
+	file := f.Args()[0]
+
+	// Read the file.
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading %s - %s\n", file, err.Error())
+		return subcommands.ExitFailure
+	}
+
+	var prog *bytecode.Program
+	var info *debuginfo.Info
+
+	if filepath.Ext(file) == ".in" {
+
+		// Compile the source.
+		e := compiler.New(lexer.New(string(input)))
+		e.SetFilename(file)
+		e.SetWordSize(int(p.mode.wordSize))
+		for _, dir := range p.includes {
+			e.AddIncludePath(dir)
+		}
+		err = e.Compile()
+		showDiagnostics(e.Diagnostics())
+		if err != nil {
+			return subcommands.ExitFailure
+		}
+		prog = e.Program()
+		info = e.DebugInfo()
+	} else {
+
+		// Load the bytecode, and any debug information.
+		prog, err = bytecode.Load(input)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+		info, _ = debuginfo.Load(debuginfo.Filename(file))
+	}
+
+	c := cpu.NewCPU()
+	p.mode.apply(c)
+	c.LoadProgram(prog)
+
+	d := debugger.New(c, info, c.STDIN, os.Stdout)
+	for _, sym := range prog.Symbols {
+		d.AddSymbol(sym.Name, sym.Address)
+	}
+
+	err = d.Run()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
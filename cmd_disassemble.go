@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/disassembler"
+)
+
+type disassembleCmd struct {
+	// The number of bits in the integers of the machine the program
+	// was compiled for.
+	wordSize wordSize
+}
+
+//
+// Glue
+//
+func (*disassembleCmd) Name() string     { return "disassemble" }
+func (*disassembleCmd) Synopsis() string { return "Convert bytecode back into source." }
+func (*disassembleCmd) Usage() string {
+	return `disassemble :
+  Convert the bytecode in the given file back into source, which may be
+  compiled again to produce identical bytecode.
+
+  Labels are named by the symbol table of the bytecode, where present.
+  Programs compiled with a -word-size should be disassembled, and then
+  compiled again, with the same -word-size.
+`
+}
+
+//
+// Flag setup
+//
+func (p *disassembleCmd) SetFlags(f *flag.FlagSet) {
+	p.wordSize = 16
+	f.Var(&p.wordSize, "word-size", "The number of bits in the integers of the machine the program was compiled for: 16, 32, or 64.")
+}
+
+//
+// Entry-point.
+//
+func (p *disassembleCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	//
+	// For each file on the command-line we can disassemble it.
+	//
+	for _, file := range f.Args() {
+
+		// Read the file.
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		// Find the contents of RAM, if this is a container.
+		prog, err := bytecode.Load(input)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		fmt.Print(disassembler.DisassembleProgram(prog, int(p.wordSize)))
+	}
+	return subcommands.ExitSuccess
+}
@@ -54,7 +54,12 @@ func (p *dumpCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 
 		// Dump it
 		e := compiler.New(l)
-		e.Dump()
+		e.SetFilename(file)
+		err = e.Dump()
+		showDiagnostics(e.Diagnostics())
+		if err != nil {
+			return subcommands.ExitFailure
+		}
 	}
 	return subcommands.ExitSuccess
 }
@@ -4,12 +4,31 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/google/subcommands"
 	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/debuginfo"
 )
 
 type executeCmd struct {
+	// Should we verify the bytecode before executing it?
+	verify bool
+
+	// Limits upon the resources the program may consume.
+	limits limitFlags
+
+	// How the machine behaves.
+	mode modeFlags
+
+	// Seeding, recording, or replaying, the program's inputs.
+	inputs inputFlags
+
+	// File to save the state of the machine to, once execution stops.
+	save string
+
+	// File to restore the state of the machine from.
+	resume string
 }
 
 //
@@ -20,13 +39,49 @@ func (*executeCmd) Synopsis() string { return "Executed a compiled program." }
 func (*executeCmd) Usage() string {
 	return `execute :
   Execute the bytecodes contained in the given input file.
+
+  The file is validated before execution, if it was written by a version
+  of 'compile' which wraps bytecode in a container.  Bare bytecode, from
+  older versions, is loaded at address zero and executed as-is.
+
+  If debug information is present alongside the bytecode, as written by
+  'compile -debug', then runtime errors will report the failing source line.
+
+  If -verify is given then the bytecode is checked, as by the 'verify'
+  command, and only executed if no problems are found.
+
+  If -max-steps is given then the program is stopped, with an error, once
+  it has executed that many instructions, and the number of steps consumed
+  is reported.  -verbose reports the steps consumed by every program.  The
+  cost of individual opcodes may only be changed via the API.  Similarly
+  -max-stack, -max-string, -max-output, and -max-input limit the depth of
+  the stack, the length of strings, and the number of bytes written and
+  read.
+
+  If -save-on-exit is given then the complete state of the machine is saved
+  to the named file once execution stops, for whatever reason.  Execution
+  may later be resumed from that point by running 'execute -resume $file',
+  in which case no bytecode need be given, and the machine's modes are
+  those it was saved with.
+
+  If -record is given then the random numbers, and lines of input, which
+  the program receives are written to the named file.  Running the program
+  with -replay and that file gives it exactly the same inputs, reporting
+  an error if its execution diverges from the recording.  Alternatively
+  -seed may be given to generate the same random numbers each time.
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *executeCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.verify, "verify", false, "Verify the bytecode before executing it.")
+	p.limits.register(f)
+	p.mode.register(f)
+	p.inputs.register(f)
+	f.StringVar(&p.save, "save-on-exit", "", "Save the state of the machine to the given file once execution stops.")
+	f.StringVar(&p.resume, "resume", "", "Resume execution from the state saved in the given file.")
 }
 
 //
@@ -34,23 +89,98 @@ func (p *executeCmd) SetFlags(f *flag.FlagSet) {
 //
 func (p *executeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	//
+	// If we're resuming then the snapshot holds the program.
+	//
+	if p.resume != "" {
+		data, err := ioutil.ReadFile(p.resume)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", p.resume, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		c := cpu.NewCPU()
+		p.limits.apply(c)
+		p.mode.apply(c)
+
+		err = c.Restore(data)
+		if err != nil {
+			fmt.Printf("Error restoring %s - %s\n", p.resume, err.Error())
+			return subcommands.ExitFailure
+		}
+		return p.run(c, nil)
+	}
+
 	//
 	// For each file on the command-line we can now execute it.
 	//
 	for _, file := range f.Args() {
 
+		if p.verify {
+			err := verifyFile(file)
+			if err != nil {
+				fmt.Printf("Error verifying file: %s\n", err)
+				return subcommands.ExitFailure
+			}
+		}
+
 		c := cpu.NewCPU()
+		p.limits.apply(c)
+		p.mode.apply(c)
 
 		err := c.LoadFile(file)
 		if err != nil {
 			fmt.Printf("Error loading file: %s\n", err)
+			return subcommands.ExitFailure
 		}
 
-		err = c.Run()
+		// Load the debug information, if present.
+		info, _ := debuginfo.Load(debuginfo.Filename(file))
+
+		status := p.run(c, info)
+		if status != subcommands.ExitSuccess {
+			return status
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+//
+// Run the program loaded into the given CPU, saving the state of the
+// machine afterwards if we should.
+//
+func (p *executeCmd) run(c *cpu.CPU, info *debuginfo.Info) subcommands.ExitStatus {
+	status := subcommands.ExitSuccess
+
+	err := p.inputs.start(c)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	err = c.Run()
+	if err != nil {
+		fmt.Printf("Error running file: %s\n", err)
+		showFault(err, info)
+		status = subcommands.ExitFailure
+	}
+	p.limits.report(c, err)
+
+	err = p.inputs.finish(c)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		status = subcommands.ExitFailure
+	}
+
+	if p.save != "" {
+		data, err := c.Snapshot()
+		if err == nil {
+			err = ioutil.WriteFile(p.save, data, 0644)
+		}
 		if err != nil {
-			fmt.Printf("Error running file: %s\n", err)
+			fmt.Printf("Error saving state to %s - %s\n", p.save, err.Error())
 			return subcommands.ExitFailure
 		}
 	}
-	return subcommands.ExitSuccess
+	return status
 }
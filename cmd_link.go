@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/object"
+)
+
+type linkCmd struct {
+	// The file to write the linked program to.
+	output string
+}
+
+//
+// Glue
+//
+func (*linkCmd) Name() string     { return "link" }
+func (*linkCmd) Synopsis() string { return "Link object files into a program." }
+func (*linkCmd) Usage() string {
+	return `link :
+  Combine the given object files, which were generated via 'compile -object',
+  into a single program of bytecode.
+
+  The objects are placed in the order given, so execution begins with the
+  first.  Symbols which are never defined, or defined more than once, are
+  reported as errors.
+
+  The program is written to the file given via -o, or to the name of the
+  first object with a .raw suffix.
+`
+}
+
+//
+// Flag setup
+//
+func (p *linkCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "o", "", "The file to write the program to.")
+}
+
+//
+// Entry-point.
+//
+func (p *linkCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if len(f.Args()) == 0 {
+		fmt.Printf("Usage: link [-o output.raw] file.obj ..\n")
+		return subcommands.ExitFailure
+	}
+
+	// Load each object.
+	var objects []*object.Object
+	for _, file := range f.Args() {
+		obj, err := object.Load(file)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+		objects = append(objects, obj)
+	}
+
+	// Link them.
+	code, err := object.Link(objects)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	// Record the exported symbols.
+	prog := bytecode.New(code)
+	base := 0
+	for _, obj := range objects {
+		for _, sym := range obj.Exports {
+			prog.AddSymbol(sym.Name, base+sym.Address)
+		}
+		base += len(obj.Code)
+	}
+
+	// Write the result.
+	output := p.output
+	if output == "" {
+		first := f.Args()[0]
+		output = strings.TrimSuffix(first, filepath.Ext(first)) + ".raw"
+	}
+	err = ioutil.WriteFile(output, prog.Encode(), 0644)
+	if err != nil {
+		fmt.Printf("Error writing %s - %s\n", output, err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
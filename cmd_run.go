@@ -13,6 +13,20 @@ import (
 )
 
 type runCmd struct {
+	// Directories to search for included files.
+	includes pathList
+
+	// File to write a listing to.
+	listing string
+
+	// Limits upon the resources the program may consume.
+	limits limitFlags
+
+	// How the machine behaves.
+	mode modeFlags
+
+	// Seeding, recording, or replaying, the program's inputs.
+	inputs inputFlags
 }
 
 //
@@ -24,13 +38,35 @@ func (*runCmd) Usage() string {
 	return `run :
   The run sub-command compiles the given source program, and then executes
   it immediately.
+
+  If -listing is given then a listing of the program, showing the address
+  and bytes generated for each source line, is written to the named file.
+
+  If -max-steps is given then the program is stopped, with an error, once
+  it has executed that many instructions, and the number of steps consumed
+  is reported.  -verbose reports the steps consumed by every program.  The
+  cost of individual opcodes may only be changed via the API.  Similarly
+  -max-stack, -max-string, -max-output, and -max-input limit the depth of
+  the stack, the length of strings, and the number of bytes written and
+  read.
+
+  If -record is given then the random numbers, and lines of input, which
+  the program receives are written to the named file.  Running the program
+  with -replay and that file gives it exactly the same inputs, reporting
+  an error if its execution diverges from the recording.  Alternatively
+  -seed may be given to generate the same random numbers each time.
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *runCmd) SetFlags(f *flag.FlagSet) {
+	f.Var(&p.includes, "I", "Add a directory to search for included files.")
+	f.StringVar(&p.listing, "listing", "", "Write a listing of the compiled program to the given file.")
+	p.limits.register(f)
+	p.mode.register(f)
+	p.inputs.register(f)
 }
 
 //
@@ -56,18 +92,54 @@ func (p *runCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 
 		// Compile it.
 		e := compiler.New(l)
-		e.Compile()
+		e.SetFilename(file)
+		e.SetWordSize(int(p.mode.wordSize))
+		for _, dir := range p.includes {
+			e.AddIncludePath(dir)
+		}
+		err = e.Compile()
+		showDiagnostics(e.Diagnostics())
+		if err != nil {
+			return subcommands.ExitFailure
+		}
+
+		// Write the listing, if we should.
+		if p.listing != "" {
+			err = e.WriteListing(p.listing)
+			if err != nil {
+				fmt.Printf("%s\n", err.Error())
+				return subcommands.ExitFailure
+			}
+		}
 
 		// Now create a machine to run the compiled program in
 		c := cpu.NewCPU()
+		p.limits.apply(c)
+		p.mode.apply(c)
 
 		// Load the program
-		c.LoadBytes(e.Output())
+		c.LoadProgram(e.Program())
+
+		// Seed, record, or replay, its inputs
+		err = p.inputs.start(c)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
 
 		// Run the machine
 		err = c.Run()
 		if err != nil {
 			fmt.Printf("Error running file: %s\n", err)
+			showFault(err, e.DebugInfo())
+		}
+		p.limits.report(c, err)
+
+		finished := p.inputs.finish(c)
+		if finished != nil {
+			fmt.Printf("%s\n", finished.Error())
+		}
+		if err != nil || finished != nil {
 			return subcommands.ExitFailure
 		}
 	}
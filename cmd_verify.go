@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/verify"
+)
+
+type verifyCmd struct {
+}
+
+//
+// Glue
+//
+func (*verifyCmd) Name() string     { return "verify" }
+func (*verifyCmd) Synopsis() string { return "Verify a compiled program." }
+func (*verifyCmd) Usage() string {
+	return `verify :
+  Check the bytecode in the given file for problems, without executing it.
+
+  Every instruction which may be reached from the entry-point is checked
+  for unknown opcodes, invalid registers, truncated strings, and jumps or
+  calls outside the program or into the middle of an instruction.
+`
+}
+
+//
+// Flag setup: no flags
+//
+func (p *verifyCmd) SetFlags(f *flag.FlagSet) {
+}
+
+//
+// Entry-point.
+//
+func (p *verifyCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	status := subcommands.ExitSuccess
+
+	//
+	// For each file on the command-line we can verify it.
+	//
+	for _, file := range f.Args() {
+		err := verifyFile(file)
+		if err != nil {
+			fmt.Printf("%s: %s\n", file, err.Error())
+			status = subcommands.ExitFailure
+			continue
+		}
+		fmt.Printf("%s: OK\n", file)
+	}
+	return status
+}
+
+// verifyFile verifies the program in the named file.
+func verifyFile(file string) error {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	prog, err := bytecode.Load(input)
+	if err != nil {
+		return err
+	}
+
+	err = verify.Program(prog)
+	if err != nil {
+		return fmt.Errorf("failed verification\n%s", err.Error())
+	}
+	return nil
+}
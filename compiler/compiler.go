@@ -19,30 +19,57 @@ package compiler
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/debuginfo"
 	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/object"
 	"github.com/skx/go.vm/opcode"
 	"github.com/skx/go.vm/token"
 )
 
 // Compiler contains our compiler-state
 type Compiler struct {
-	l         *lexer.Lexer   // our lexer
-	curToken  token.Token    // current token
-	peekToken token.Token    // next token
-	bytecode  []byte         // generated bytecode
-	labels    map[string]int // holder for labels
-	fixups    map[int]string // holder for fixups
+	l           *lexer.Lexer           // our lexer
+	filename    string                 // name of the file we're compiling
+	curToken    token.Token            // current token
+	peekToken   token.Token            // next token
+	bytecode    []byte                 // generated bytecode
+	labels      map[string]int         // holder for labels
+	definitions map[string]token.Token // where each label was defined
+	references  []token.Token          // every use of a label
+	globals     map[string]token.Token // labels exported via .global
+	entry       *token.Token           // label given via .entry, if any
+	relocatable bool                   // are we generating an object?
+	relocations []object.Relocation    // relocations for our object
+	fixups      map[int]*fixup         // holder for fixups
+	constants   map[string]*constant   // constants defined via .equ
+	diagnostics Diagnostics            // problems found during compilation
+	lines       []debuginfo.Line       // source-lines of generated bytecode
+	macros      map[string]*macro      // macros which have been defined
+	pending     []token.Token          // expanded tokens to read before the lexer
+	expansions  int                    // count of macro-expansions
+	paths       []string               // directories to search for includes
+	includedBy  map[string]inclusion   // how each included file was found
+	sources     map[string]*source     // text of included files
+	width       int                    // number of bits in a word
 }
 
 // New is our constructor
 func New(l *lexer.Lexer) *Compiler {
 	p := &Compiler{l: l}
 	p.labels = make(map[string]int)
-	p.fixups = make(map[int]string)
+	p.definitions = make(map[string]token.Token)
+	p.globals = make(map[string]token.Token)
+	p.fixups = make(map[int]*fixup)
+	p.constants = make(map[string]*constant)
+	p.macros = make(map[string]*macro)
+	p.includedBy = make(map[string]inclusion)
+	p.sources = make(map[string]*source)
+	p.width = 16
 
 	// prime the pump.
 	p.nextToken()
@@ -50,10 +77,54 @@ func New(l *lexer.Lexer) *Compiler {
 	return p
 }
 
+// SetFilename sets the name of the file being compiled, which is used
+// when reporting diagnostics.
+func (p *Compiler) SetFilename(name string) {
+	p.filename = name
+}
+
+// SetWordSize sets the number of bits in the words of the machine which
+// will execute our program, which must be 16, the default, 32, or 64.
+//
+// Numbers stored in, or compared with, a register must fit in a word,
+// and negative numbers are written in two's complement form to suit it.
+func (p *Compiler) SetWordSize(size int) error {
+	switch size {
+	case 16, 32, 64:
+	default:
+		return fmt.Errorf("unsupported word size %d, expected 16, 32, or 64", size)
+	}
+	p.width = size
+	return nil
+}
+
 // nextToken gets the next token from our lexer-stream
 func (p *Compiler) nextToken() {
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.peekToken = p.readToken()
+}
+
+// readToken returns the next token to process, which will come from
+// any pending macro-expansion before our lexer.
+func (p *Compiler) readToken() token.Token {
+	if len(p.pending) > 0 {
+		tok := p.pending[0]
+		p.pending = p.pending[1:]
+		return tok
+	}
+	return p.l.NextToken()
+}
+
+// insertTokens arranges for the given tokens to be processed after the
+// current one, ahead of the rest of our input.
+func (p *Compiler) insertTokens(tokens []token.Token) {
+	if len(tokens) == 0 {
+		return
+	}
+
+	rest := append([]token.Token{p.peekToken}, p.pending...)
+	p.peekToken = tokens[0]
+	p.pending = append(tokens[1:], rest...)
 }
 
 // isRegister returns true if the given string has a register ID
@@ -62,51 +133,171 @@ func (p *Compiler) isRegister(input string) bool {
 }
 
 // getRegister converts a register string "#2" to an integer 2.
+//
+// Invalid registers are reported as errors, and zero is returned so
+// that compilation may continue.
 func (p *Compiler) getRegister(input string) byte {
 
+	if !p.isRegister(input) {
+		p.errorf("expected a register, got '%s'", input)
+		return 0
+	}
+
 	num := strings.TrimPrefix(input, "#")
 
 	i, err := strconv.Atoi(num)
 	if err != nil {
-		panic(err)
+		p.errorf("invalid register '%s'", input)
+		return 0
 	}
 
 	if (i >= 0) && (i <= 15) {
 		return byte(i)
 	}
 
-	fmt.Printf("Register out of bounds: #%s\n", input)
-	os.Exit(1)
+	p.errorf("register out of bounds: %s", input)
 	return 0
 }
 
+// getNumber converts the literal of an integer-token to a number.
+//
+// Numbers too large for an int64, such as 0xFFFFFFFFFFFFFFFF, are
+// accepted if they fit in 64 bits, and held in two's complement form.
+//
+// Invalid numbers are reported as errors, and zero is returned so
+// that compilation may continue.
+func (p *Compiler) getNumber(input string) int64 {
+	i, err := strconv.ParseInt(input, 0, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(input, 0, 64)
+		if err != nil {
+			p.errorf("invalid number '%s'", input)
+			return 0
+		}
+		return int64(u)
+	}
+	return i
+}
+
+// errorf records an error against the position of the current token.
+func (p *Compiler) errorf(format string, args ...interface{}) {
+	p.report(Error, p.curToken.Pos, format, args...)
+}
+
+// errorAt records an error against the position of the given token.
+func (p *Compiler) errorAt(tok token.Token, format string, args ...interface{}) {
+	p.report(Error, tok.Pos, format, args...)
+}
+
+// warnAt records a warning at the position of the given token.
+func (p *Compiler) warnAt(tok token.Token, format string, args ...interface{}) {
+	p.report(Warning, tok.Pos, format, args...)
+}
+
+// fileOf returns the name of the file the given position is within.
+//
+// Tokens read from included files record their file, otherwise they
+// came from the file we're compiling.
+func (p *Compiler) fileOf(pos token.Position) string {
+	if pos.File != "" {
+		return pos.File
+	}
+	return p.filename
+}
+
+// report records a diagnostic of the given severity.
+func (p *Compiler) report(severity Severity, pos token.Position, format string, args ...interface{}) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		File:     p.fileOf(pos),
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// errorCount returns the number of errors we've recorded so far.
+func (p *Compiler) errorCount() int {
+	count := 0
+	for _, d := range p.diagnostics {
+		if d.Severity == Error {
+			count++
+		}
+	}
+	return count
+}
+
+// synchronize is called after an error has been found in a statement,
+// it skips any remaining tokens until we reach the start of the next
+// statement - which avoids reporting a cascade of bogus errors.
+func (p *Compiler) synchronize() {
+	for !p.peekTokenIs(token.EOF) && !p.isStatement(p.peekToken) {
+		p.nextToken()
+	}
+}
+
+// isStatement returns true if the given token may begin a statement.
+func (p *Compiler) isStatement(tok token.Token) bool {
+	switch tok.Type {
+	case token.IDENT:
+		// The use of a macro is a statement.
+		_, ok := p.macros[tok.Literal]
+		return ok
+	case token.INT, token.STRING, token.COMMA, token.ILLEGAL:
+		return false
+	}
+	return true
+}
+
 // Dump processe the stream of tokens from the lexer and shows the structure
 // of the program.
-func (p *Compiler) Dump() {
+//
+// Each token is prefixed by its line, column, and byte-offset.
+//
+// Any illegal tokens are reported as errors.
+func (p *Compiler) Dump() error {
 
 	// Until we get the end of our stream we'll show each token.
 	for p.curToken.Type != token.EOF {
-		fmt.Printf("%v\n", p.curToken)
+		tok := p.curToken
+		fmt.Printf("%d:%d\t@%d\t{%s %s}\n", tok.Pos.Line, tok.Pos.Column, tok.Pos.Offset, tok.Type, tok.Literal)
+		if p.curToken.Type == token.ILLEGAL {
+			p.errorf("illegal token '%s'", p.curToken.Literal)
+		}
 		p.nextToken()
 	}
+
+	if p.diagnostics.HasErrors() {
+		return p.diagnostics
+	}
+	return nil
 }
 
 // Compile processe the stream of tokens from the lexer and builds
 // up the bytecode program.
-func (p *Compiler) Compile() {
+//
+// If any errors are found the return value will be of type Diagnostics,
+// listing every problem found in the program.  Warnings alone do not
+// cause an error to be returned, but they are available via the
+// `Diagnostics` method.
+func (p *Compiler) Compile() error {
 
 	// Until we get the end of our stream we'll process each token
 	// in turn, generating bytecode as we go.
 	for p.curToken.Type != token.EOF {
 
+		before := p.errorCount()
+		start := len(p.bytecode)
+		tok := p.curToken
+
 		// Now handle the various tokens
 		switch p.curToken.Type {
 
 		case token.LABEL:
-			// Remove the ":" prefix from the label
-			label := strings.TrimPrefix(p.curToken.Literal, ":")
-			// The label points to the current point in our bytecode
-			p.labels[label] = len(p.bytecode)
+			p.labelDefinition()
+
+		case token.EQU, token.DEFINE:
+			p.constantDefinition()
 
 		case token.EXIT:
 			p.exitOp()
@@ -165,6 +356,36 @@ func (p *Compiler) Compile() {
 		case token.JMPNZ:
 			p.jumpOp(opcode.JUMP_NZ)
 
+		case token.JMPC:
+			p.jumpOp(opcode.JUMP_C)
+
+		case token.JMPNC:
+			p.jumpOp(opcode.JUMP_NC)
+
+		case token.JMPO:
+			p.jumpOp(opcode.JUMP_O)
+
+		case token.JMPNO:
+			p.jumpOp(opcode.JUMP_NO)
+
+		case token.JMPN:
+			p.jumpOp(opcode.JUMP_N)
+
+		case token.JMPNN:
+			p.jumpOp(opcode.JUMP_NN)
+
+		case token.JMPLT:
+			p.jumpOp(opcode.JUMP_LT)
+
+		case token.JMPLE:
+			p.jumpOp(opcode.JUMP_LE)
+
+		case token.JMPGT:
+			p.jumpOp(opcode.JUMP_GT)
+
+		case token.JMPGE:
+			p.jumpOp(opcode.JUMP_GE)
+
 		case token.MEMCPY:
 			p.memcpyOp()
 
@@ -213,26 +434,105 @@ func (p *Compiler) Compile() {
 		case token.OR:
 			p.mathOperation(opcode.OR_OP)
 
+		case token.SHL:
+			p.mathOperation(opcode.SHL_OP)
+
+		case token.SHR:
+			p.mathOperation(opcode.SHR_OP)
+
+		case token.INCLUDE:
+			p.includeFile()
+
+		case token.GLOBAL:
+			p.globalDeclaration()
+
+		case token.ENTRY:
+			p.entryDeclaration()
+
+		case token.MACRO:
+			p.macroDefinition()
+
+		case token.ENDM:
+			p.errorf(".endm without .macro")
+
+		case token.IDENT:
+			p.macroExpansion()
+
+		case token.ILLEGAL:
+			p.errorf("illegal token '%s'", p.curToken.Literal)
+
 		default:
-			fmt.Println("Unhandled token: ", p.curToken)
+			p.errorf("unexpected %s '%s'", p.curToken.Type, p.curToken.Literal)
 
 		}
+
+		// Record the source of each statement, along with any
+		// bytecode it generated.
+		p.lines = append(p.lines, debuginfo.Line{
+			Address: start,
+			Length:  len(p.bytecode) - start,
+			File:    p.fileOf(tok.Pos),
+			Line:    tok.Pos.Line,
+		})
+
+		// If we found an error skip to the next statement.
+		if p.errorCount() != before {
+			p.synchronize()
+		}
 		p.nextToken()
 	}
 
-	// Now fixup any label-names we've got to patch into place.
-	for addr, name := range p.fixups {
-		value := p.labels[name]
-		if value == 0 {
-			fmt.Printf("Possible use of undefined label '%s'\n", name)
+	// Ensure every label we've used was defined.
+	p.checkLabels()
+	p.checkEntry()
+
+	// Now fixup any expressions involving labels, which we've got
+	// to patch into place.
+	label := func(e *expression) int64 {
+		return int64(p.labels[e.name])
+	}
+
+	var addrs []int
+	for addr := range p.fixups {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	for _, addr := range addrs {
+		f := p.fixups[addr]
+		if p.relocatable {
+			p.relocate(addr, f)
+		} else if p.resolved(f.expr) {
+			p.writeValue(addr, f, label)
 		}
+	}
 
-		p1 := value % 256
-		p2 := (value - p1) / 256
+	if p.diagnostics.HasErrors() {
+		return p.diagnostics
+	}
+	return nil
+}
 
-		p.bytecode[addr] = byte(p1)
-		p.bytecode[addr+1] = byte(p2)
+// DebugInfo returns the debugging information for the compiled program,
+// mapping bytecode offsets to source lines and label names.
+func (p *Compiler) DebugInfo() *debuginfo.Info {
+	info := &debuginfo.Info{}
+	for _, line := range p.lines {
+		if line.Length == 0 {
+			continue
+		}
+		info.AddLine(line.Address, line.Length, line.File, line.Line)
+	}
+	for name, addr := range p.labels {
+		info.AddLabel(name, addr)
 	}
+	return info
+}
+
+// Diagnostics returns all the errors and warnings which were found
+// during compilation.
+func (p *Compiler) Diagnostics() Diagnostics {
+	return p.diagnostics
 }
 
 // nopOp does nothing
@@ -257,6 +557,7 @@ func (p *Compiler) peekOp() {
 
 	// and a literal
 	if p.curToken.Type != token.IDENT {
+		p.errorf("expected a register, got %s '%s'", p.curToken.Type, p.curToken.Literal)
 		return
 	}
 	addr := p.getRegister(p.curToken.Literal)
@@ -284,6 +585,7 @@ func (p *Compiler) pokeOp() {
 
 	// and a literal
 	if p.curToken.Type != token.IDENT {
+		p.errorf("expected a register, got %s '%s'", p.curToken.Type, p.curToken.Literal)
 		return
 	}
 	addr := p.getRegister(p.curToken.Literal)
@@ -356,7 +658,8 @@ func (p *Compiler) decOp() {
 	p.bytecode = append(p.bytecode, byte(reg))
 }
 
-// randOp returns a random value
+// randOp returns a random value, optionally within the range given by
+// two further registers.
 func (p *Compiler) randOp() {
 
 	// We're looking for an identifier next.
@@ -367,8 +670,33 @@ func (p *Compiler) randOp() {
 	// Save the register we're storing to.
 	reg := p.getRegister(p.curToken.Literal)
 
-	p.bytecode = append(p.bytecode, byte(opcode.INT_RANDOM))
+	// No range?
+	if !p.peekTokenIs(token.COMMA) {
+		p.bytecode = append(p.bytecode, byte(opcode.INT_RANDOM))
+		p.bytecode = append(p.bytecode, byte(reg))
+		return
+	}
+
+	// The minimum
+	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	min := p.getRegister(p.curToken.Literal)
+
+	// The maximum
+	if !p.expectPeek(token.COMMA) {
+		return
+	}
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	max := p.getRegister(p.curToken.Literal)
+
+	p.bytecode = append(p.bytecode, byte(opcode.INT_RANDOM_RANGE))
 	p.bytecode = append(p.bytecode, byte(reg))
+	p.bytecode = append(p.bytecode, byte(min))
+	p.bytecode = append(p.bytecode, byte(max))
 }
 
 // retOp returns from a call
@@ -454,50 +782,23 @@ func (p *Compiler) callOp() {
 	// advance to the target
 	p.nextToken()
 
-	// The call might be to an absolute target, or a label.
-	switch p.curToken.Type {
-
-	case token.INT:
-		addr, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-
-		len1 := addr % 256
-		len2 := (addr - len1) / 256
-
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
-
-	case token.IDENT:
-
-		// Record that we have to fixup this thing
-		p.fixups[len(p.bytecode)] = p.curToken.Literal
-
-		// output two temporary numbers
-		p.bytecode = append(p.bytecode, byte(0))
-		p.bytecode = append(p.bytecode, byte(0))
-	}
-
+	// The call might be to an absolute target, a label, or an
+	// expression involving both.
+	p.emitExpression(2)
 }
 
 // trapOp inserts an interrupt call / trap
 func (p *Compiler) trapOp() {
 
-	// advance to the target
-	p.nextToken()
-
-	// The jump might be an absolute target, or a label.
-	switch p.curToken.Type {
+	// add the trap instruction
+	p.bytecode = append(p.bytecode, byte(opcode.TRAP_OP))
 
-	case token.INT:
-		addr, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-		len1 := addr % 256
-		len2 := (addr - len1) / 256
+	// advance to the trap number
+	p.nextToken()
 
-		p.bytecode = append(p.bytecode, byte(opcode.TRAP_OP))
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
-	default:
-		fmt.Printf("Fail!")
-	}
+	// The trap number might be a literal, a constant, or an
+	// expression.
+	p.emitExpression(2)
 }
 
 // jumpOp inserts a direct jump
@@ -509,27 +810,9 @@ func (p *Compiler) jumpOp(operator int) {
 	// advance to the target
 	p.nextToken()
 
-	// The jump might be an absolute target, or a label.
-	switch p.curToken.Type {
-
-	case token.INT:
-		addr, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-		len1 := addr % 256
-		len2 := (addr - len1) / 256
-
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
-
-	case token.IDENT:
-
-		// Record that we have to fixup this thing
-		p.fixups[len(p.bytecode)] = p.curToken.Literal
-
-		// output two temporary numbers
-		p.bytecode = append(p.bytecode, byte(0))
-		p.bytecode = append(p.bytecode, byte(0))
-	}
-
+	// The jump might be an absolute target, a label, or an
+	// expression involving both.
+	p.emitExpression(2)
 }
 
 // memcpyOp inserts a memcopy operation.
@@ -578,6 +861,7 @@ func (p *Compiler) mathOperation(operation int) {
 
 	// and a literal
 	if p.curToken.Type != token.IDENT {
+		p.errorf("expected a register, got %s '%s'", p.curToken.Type, p.curToken.Literal)
 		return
 	}
 	src1 := p.getRegister(p.curToken.Literal)
@@ -590,6 +874,7 @@ func (p *Compiler) mathOperation(operation int) {
 
 	// and a final literal
 	if p.curToken.Type != token.IDENT {
+		p.errorf("expected a register, got %s '%s'", p.curToken.Type, p.curToken.Literal)
 		return
 	}
 	src2 := p.getRegister(p.curToken.Literal)
@@ -639,40 +924,21 @@ func (p *Compiler) storeOp() {
 		for i := 0; i < len; i++ {
 			p.bytecode = append(p.bytecode, byte(p.curToken.Literal[i]))
 		}
-	case token.INT:
-		// INT_STORE $REG $NUM1 NUM2
-		p.bytecode = append(p.bytecode, byte(opcode.INT_STORE))
-		p.bytecode = append(p.bytecode, reg)
-
-		// Convert to low/high
-		i, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-		len1 := i % 256
-		len2 := (i - len1) / 256
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
-	case token.IDENT:
+	case token.IDENT, token.INT, token.LPAREN, token.MINUS:
 		if p.isRegister(p.curToken.Literal) {
 			// REG_STORE REG_DST REG_SRC
 			p.bytecode = append(p.bytecode, byte(opcode.REG_STORE))
 			p.bytecode = append(p.bytecode, reg)
 			p.bytecode = append(p.bytecode, p.getRegister(p.curToken.Literal))
 		} else {
-			// Here we're storing the address of a label.
-
-			// INT_STORE $REG $NUM1 $NUM2
-			p.bytecode = append(p.bytecode, byte(opcode.INT_STORE))
-			p.bytecode = append(p.bytecode, reg)
-
-			// record that we need a fixup here
-			p.fixups[len(p.bytecode)] = p.curToken.Literal
+			// Here we're storing a number, the address of a
+			// label, or an expression.
 
-			// output two temporary numbers
-			p.bytecode = append(p.bytecode, byte(0))
-			p.bytecode = append(p.bytecode, byte(0))
+			// INT_STORE $REG $NUM1 $NUM2, or a wider form.
+			p.emitImmediate(reg, opcode.INT_STORE, opcode.INT_STORE32, opcode.INT_STORE64)
 		}
 	default:
-		fmt.Printf("ERROR: Invalid thing to store: %v\n", p.curToken)
-		os.Exit(1)
+		p.errorf("invalid thing to store: %s '%s'", p.curToken.Type, p.curToken.Literal)
 	}
 }
 
@@ -714,41 +980,21 @@ func (p *Compiler) cmpOp() {
 		for i := 0; i < len; i++ {
 			p.bytecode = append(p.bytecode, byte(p.curToken.Literal[i]))
 		}
-	case token.INT:
-		// CMP_IMMEDIATE $REG $NUM1 NUM2
-		p.bytecode = append(p.bytecode, byte(opcode.CMP_IMMEDIATE))
-		p.bytecode = append(p.bytecode, reg)
-
-		// Convert to low/high
-		i, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-
-		len1 := i % 256
-		len2 := (i - len1) / 256
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
-	case token.IDENT:
+	case token.IDENT, token.INT, token.LPAREN, token.MINUS:
 		if p.isRegister(p.curToken.Literal) {
 			// CMP_REG REG_DST REG_SRC
 			p.bytecode = append(p.bytecode, byte(opcode.CMP_REG))
 			p.bytecode = append(p.bytecode, reg)
 			p.bytecode = append(p.bytecode, p.getRegister(p.curToken.Literal))
 		} else {
-			// Here we're storing the address of a label.
-
-			// INT_STORE $REG $NUM1 $NUM2
-			p.bytecode = append(p.bytecode, byte(opcode.CMP_IMMEDIATE))
-			p.bytecode = append(p.bytecode, reg)
-
-			// record that we need a fixup here
-			p.fixups[len(p.bytecode)] = p.curToken.Literal
+			// Here we're comparing with a number, the address
+			// of a label, or an expression.
 
-			// output two temporary numbers
-			p.bytecode = append(p.bytecode, byte(0))
-			p.bytecode = append(p.bytecode, byte(0))
+			// CMP_IMMEDIATE $REG $NUM1 $NUM2, or a wider form.
+			p.emitImmediate(reg, opcode.CMP_IMMEDIATE, opcode.CMP_IMMEDIATE32, opcode.CMP_IMMEDIATE64)
 		}
 	default:
-		fmt.Printf("ERROR: Invalid thing to store: %v\n", p.curToken)
-		os.Exit(1)
+		p.errorf("invalid thing to compare: %s '%s'", p.curToken.Type, p.curToken.Literal)
 	}
 }
 
@@ -781,40 +1027,30 @@ func (p *Compiler) concatOp() {
 
 // dataOp embeds literal/binary data into the output
 func (p *Compiler) dataOp() {
-	p.nextToken()
 
-	// We might have a string, or a series of ints
 	//
-	// If it is a string handle that first
-	if p.curToken.Type == token.STRING {
-		len := len(p.curToken.Literal)
-		for i := 0; i < len; i++ {
-			p.bytecode = append(p.bytecode, byte(p.curToken.Literal[i]))
+	// Loop over the data - we don't know how much there might be,
+	// but we'll know it is comma-separated.
+	//
+	for {
+		p.nextToken()
+
+		// We might have a string, or a byte-sized expression.
+		if p.curToken.Type == token.STRING {
+			len := len(p.curToken.Literal)
+			for i := 0; i < len; i++ {
+				p.bytecode = append(p.bytecode, byte(p.curToken.Literal[i]))
+			}
+		} else {
+			p.emitNumber(1)
 		}
-		return
-	}
 
-	//
-	// Otherwise we expect a single int
-	//
-	db := p.curToken.Literal
-	i, _ := strconv.ParseInt(db, 0, 64)
-	p.bytecode = append(p.bytecode, byte(i))
+		if !p.peekTokenIs(token.COMMA) {
+			return
+		}
 
-	//
-	// Loop looking for more data - we don't know how much
-	// there might be, but we'll know it is comma-separated.
-	//
-	for p.peekTokenIs(token.COMMA) {
 		// skip the comma
 		p.nextToken()
-
-		// read the next int
-		if p.expectPeek(token.INT) {
-			db := p.curToken.Literal
-			i, _ := strconv.ParseInt(db, 0, 64)
-			p.bytecode = append(p.bytecode, byte(i))
-		}
 	}
 }
 
@@ -859,19 +1095,33 @@ func (p *Compiler) expectPeek(t token.Type) bool {
 	return false
 }
 
+// peekError records the error of finding an unexpected token.
 func (p *Compiler) peekError(t token.Type) {
-	fmt.Printf("expected next token to be %s, got %s instead", t, p.curToken.Type)
-	os.Exit(1)
+	p.errorAt(p.peekToken, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
-// Write outputs our generated bytecode to the named file.
-func (p *Compiler) Write(output string) {
+// Write outputs our generated bytecode, within our container, to the
+// named file.
+func (p *Compiler) Write(output string) error {
 	fmt.Printf("Our bytecode is %d bytes long\n", len(p.bytecode))
-	err := ioutil.WriteFile(output, p.bytecode, 0644)
+	err := ioutil.WriteFile(output, p.Program().Encode(), 0644)
 	if err != nil {
-		fmt.Printf("Error writing output file: %s\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error writing output file: %s", err.Error())
+	}
+	return nil
+}
+
+// Program returns the compiled program, including the address of each
+// label, ready to be written within our bytecode container.
+func (p *Compiler) Program() *bytecode.Program {
+	prog := bytecode.New(p.bytecode)
+	if p.entry != nil {
+		prog.Entry = p.labels[p.entry.Literal]
+	}
+	for _, name := range p.sortedLabels() {
+		prog.AddSymbol(name, p.labels[name])
 	}
+	return prog
 }
 
 // Output returns the bytecodes of the compiled program.
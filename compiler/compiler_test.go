@@ -0,0 +1,625 @@
+package compiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/object"
+	"github.com/skx/go.vm/opcode"
+)
+
+// compile is a helper which compiles the given source.
+func compile(t *testing.T, input string) (*Compiler, error) {
+	t.Helper()
+
+	c := New(lexer.New(input))
+	c.SetFilename("test.in")
+	return c, c.Compile()
+}
+
+// TestCompile ensures a valid program compiles without diagnostics.
+func TestCompile(t *testing.T) {
+	c, err := compile(t, `
+        store #1, 0x0a
+        store #2, "steve"
+        add #0, #1, #1
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(c.Diagnostics()) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", c.Diagnostics())
+	}
+	if len(c.Output()) != 4+9+4+1 {
+		t.Fatalf("unexpected output length %d", len(c.Output()))
+	}
+}
+
+// TestCompileErrors ensures that bogus programs report every problem,
+// rather than terminating.
+func TestCompileErrors(t *testing.T) {
+
+	_, err := compile(t, `
+        store #99, 3
+        store #1, ,
+        add #1, 2, #3
+        inc #1x
+        exit
+`)
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("expected Diagnostics, got %T", err)
+	}
+
+	expected := []struct {
+		line    int
+		column  int
+		message string
+	}{
+		{2, 15, "register out of bounds: #99"},
+		{3, 19, "invalid thing to store: COMMA"},
+		{4, 17, "expected a register, got INT"},
+		{5, 13, "invalid register '#1x'"},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %s", len(expected), len(diags), err)
+	}
+	for i, e := range expected {
+		if !strings.Contains(diags[i].Message, e.message) {
+			t.Errorf("diagnostic %d was '%s', expected '%s'", i, diags[i].Message, e.message)
+		}
+		if diags[i].Line != e.line || diags[i].Column != e.column {
+			t.Errorf("diagnostic %d at %d:%d, expected %d:%d", i, diags[i].Line, diags[i].Column, e.line, e.column)
+		}
+		if diags[i].Severity != Error {
+			t.Errorf("diagnostic %d has wrong severity %s", i, diags[i].Severity)
+		}
+		if !strings.HasPrefix(diags[i].String(), fmt.Sprintf("test.in:%d:%d: error: ", e.line, e.column)) {
+			t.Errorf("diagnostic %d has the wrong format: %s", i, diags[i])
+		}
+	}
+}
+
+// TestMacros ensures macros expand to the same code as writing the body
+// out by hand, with local labels which don't collide.
+func TestMacros(t *testing.T) {
+	c, err := compile(t, `
+.macro countdown reg, count
+        store reg, count
+:loop
+        dec reg
+        jmpnz loop
+.endm
+        countdown #1, 3
+        countdown #2, 0x10
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected, err := compile(t, `
+        store #1, 3
+:loop1
+        dec #1
+        jmpnz loop1
+        store #2, 0x10
+:loop2
+        dec #2
+        jmpnz loop2
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(c.Output()) != string(expected.Output()) {
+		t.Fatalf("macro expansion mismatch:\n% X\n% X", c.Output(), expected.Output())
+	}
+}
+
+// TestMacroErrors ensures bogus macros are reported.
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{".macro m\n exit\n", "has no matching .endm"},
+		{".endm", ".endm without .macro"},
+		{".macro m\n.macro n\n.endm\n.endm", "macros may not be defined within"},
+		{".macro m\n.endm\n.macro m\n.endm", "already defined"},
+		{".macro m #1\n.endm", "may not be a register"},
+		{".macro m a\n inc a\n.endm\n m ,", "expected argument 'a'"},
+		{".macro m\n m\n.endm\n m", "too many macro expansions"},
+	}
+
+	for _, test := range tests {
+		_, err := compile(t, test.input)
+		if err == nil {
+			t.Fatalf("expected error compiling %s", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got '%s'", test.error, err)
+		}
+	}
+}
+
+// TestInclude tests including files, via relative paths and include
+// paths, along with the detection of cycles.
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "include")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.in":      ".include \"lib/print.in\"\n.include \"extra.in\"\n        exit\n",
+		"lib/print.in": "        store #1, \"hello\"\n        print_str #1\n",
+		"inc/extra.in": "        nop\n",
+		"cycle.in":     ".include \"lib/a.in\"\n",
+		"lib/a.in":     ".include \"b.in\"\n",
+		"lib/b.in":     ".include \"a.in\"\n",
+		"broken.in":    ".include \"lib/bogus.in\"\n",
+		"lib/bogus.in": "        nop\n        bogus\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+
+	// load compiles the given file
+	load := func(name string) (*Compiler, error) {
+		path := filepath.Join(dir, name)
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		c := New(lexer.New(string(src)))
+		c.SetFilename(path)
+		c.AddIncludePath(filepath.Join(dir, "inc"))
+		return c, c.Compile()
+	}
+
+	c, err := load("main.in")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected, _ := compile(t, files["lib/print.in"]+files["inc/extra.in"]+"exit\n")
+	if string(c.Output()) != string(expected.Output()) {
+		t.Fatalf("include mismatch:\n% X\n% X", c.Output(), expected.Output())
+	}
+
+	// The listing should interleave the included files.
+	var listing strings.Builder
+	c.Listing(&listing)
+	rest := listing.String()
+	for _, text := range []string{".include \"lib/print.in\"", "lib/print.in <==", "print_str #1",
+		"main.in <==", ".include \"extra.in\"", "extra.in <==", "nop", "main.in <==", "exit"} {
+		i := strings.Index(rest, text)
+		if i < 0 {
+			t.Fatalf("listing is missing '%s':\n%s", text, listing.String())
+		}
+		rest = rest[i+len(text):]
+	}
+
+	_, err = load("cycle.in")
+	if err == nil || !strings.Contains(err.Error(), "include cycle: ") {
+		t.Fatalf("expected a cycle, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "lib/a.in -> ") {
+		t.Fatalf("cycle should name the files involved: %s", err)
+	}
+
+	_, err = load("broken.in")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	diags := err.(Diagnostics)
+	if len(diags) != 1 || diags[0].File != filepath.Join(dir, "lib/bogus.in") || diags[0].Line != 2 {
+		t.Fatalf("diagnostic should name the included file and line: %s", err)
+	}
+
+	_, err = compile(t, ".include \"missing.in\"")
+	if err == nil || !strings.Contains(err.Error(), "failed to find include file") {
+		t.Fatalf("expected a missing file, got %v", err)
+	}
+}
+
+// TestJumps ensures each jump compiles to the right opcode.
+func TestJumps(t *testing.T) {
+	tests := map[string]int{
+		"jmp":   opcode.JUMP_TO,
+		"jmpz":  opcode.JUMP_Z,
+		"jmpnz": opcode.JUMP_NZ,
+		"jmpc":  opcode.JUMP_C,
+		"jmpnc": opcode.JUMP_NC,
+		"jmpo":  opcode.JUMP_O,
+		"jmpno": opcode.JUMP_NO,
+		"jmpn":  opcode.JUMP_N,
+		"jmpnn": opcode.JUMP_NN,
+		"jmplt": opcode.JUMP_LT,
+		"jmple": opcode.JUMP_LE,
+		"jmpgt": opcode.JUMP_GT,
+		"jmpge": opcode.JUMP_GE,
+	}
+
+	for jump, op := range tests {
+		c, err := compile(t, ":start\n        "+jump+" end\n:end\n        exit\n")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", jump, err)
+		}
+		expected := []byte{byte(op), 0x03, 0x00, byte(opcode.EXIT)}
+		if !reflect.DeepEqual(c.Output(), expected) {
+			t.Errorf("%s: expected % X, got % X", jump, expected, c.Output())
+		}
+	}
+}
+
+// TestExpressions ensures constants and expressions compile to the same
+// code as the literal values they describe.
+func TestExpressions(t *testing.T) {
+	c, err := compile(t, `
+.equ SIZE 4
+.define LIMIT, SIZE*(2+1)-1
+        store #1, LIMIT
+        store #2, buffer+SIZE/2
+        cmp #1, -(-LIMIT)
+        jmp end-1
+        int LIMIT-10
+:buffer
+        DB SIZE, 0xff, "x", LIMIT*2
+:end
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected, err := compile(t, `
+        store #1, 11
+        store #2, 20
+        cmp #1, 11
+        jmp 21
+        int 1
+        DB 4, 0xff, "x", 22
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(c.Output()) != string(expected.Output()) {
+		t.Fatalf("expression mismatch:\n% X\n% X", c.Output(), expected.Output())
+	}
+}
+
+// TestNegative ensures negative numbers are written in two's complement
+// form.
+func TestNegative(t *testing.T) {
+	c, err := compile(t, `
+        store #1, -5
+        cmp #1, -0x8000
+        DB -1, -128
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []byte{
+		byte(opcode.INT_STORE), 0x01, 0xFB, 0xFF,
+		byte(opcode.CMP_IMMEDIATE), 0x01, 0x00, 0x80,
+		0xFF, 0x80,
+	}
+	if !reflect.DeepEqual(c.Output(), expected) {
+		t.Fatalf("expected % X, got % X", expected, c.Output())
+	}
+}
+
+// TestWide ensures numbers are written using the shortest encoding which
+// can hold them, in a word of the given size.
+func TestWide(t *testing.T) {
+	tests := []struct {
+		size     int
+		input    string
+		expected []byte
+		error    string
+	}{
+		{16, "store #1, -1", []byte{byte(opcode.INT_STORE), 0x01, 0xFF, 0xFF}, ""},
+		{16, "store #1, 0x10000", nil, "value 65536 is outside the range -32768..65535"},
+		{16, "store #1, 0xFFFFFFFFFFFFFFFF", nil, "value 0xFFFFFFFFFFFFFFFF does not fit in a 16-bit word"},
+		{32, "store #1, 0xFFFF", []byte{byte(opcode.INT_STORE), 0x01, 0xFF, 0xFF}, ""},
+		{32, "store #1, -1", []byte{byte(opcode.INT_STORE32), 0x01, 0xFF, 0xFF, 0xFF, 0xFF}, ""},
+		{32, "store #1, 0x10000", []byte{byte(opcode.INT_STORE32), 0x01, 0x00, 0x00, 0x01, 0x00}, ""},
+		{32, "store #1, 0xFFFFFFFF", []byte{byte(opcode.INT_STORE32), 0x01, 0xFF, 0xFF, 0xFF, 0xFF}, ""},
+		{32, "cmp #1, -0x80000000", []byte{byte(opcode.CMP_IMMEDIATE32), 0x01, 0x00, 0x00, 0x00, 0x80}, ""},
+		{32, "store #1, 0x100000000", nil, "value 4294967296 does not fit in a 32-bit word"},
+		{32, "cmp #1, -0x80000001", nil, "value -2147483649 does not fit in a 32-bit word"},
+		{64, "store #1, -1", []byte{byte(opcode.INT_STORE32), 0x01, 0xFF, 0xFF, 0xFF, 0xFF}, ""},
+		{64, "store #1, 0x80000000", []byte{byte(opcode.INT_STORE64), 0x01, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00}, ""},
+		{64, "store #1, 0xFFFFFFFFFFFFFFFF", []byte{byte(opcode.INT_STORE64), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, ""},
+		{64, "cmp #1, 0x12345678", []byte{byte(opcode.CMP_IMMEDIATE32), 0x01, 0x78, 0x56, 0x34, 0x12}, ""},
+		{64, "cmp #1, 0x123456789A", []byte{byte(opcode.CMP_IMMEDIATE64), 0x01, 0x9A, 0x78, 0x56, 0x34, 0x12, 0x00, 0x00, 0x00}, ""},
+	}
+
+	for _, test := range tests {
+		c := New(lexer.New(test.input))
+		c.SetFilename("test.in")
+		err := c.SetWordSize(test.size)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		err = c.Compile()
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%d-bit %s: expected error '%s', got %v", test.size, test.input, test.error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(c.Output(), test.expected) {
+			t.Errorf("%d-bit %s: expected % X, got % X", test.size, test.input, test.expected, c.Output())
+		}
+	}
+
+	if New(lexer.New("")).SetWordSize(8) == nil {
+		t.Errorf("expected an error setting an 8-bit word")
+	}
+}
+
+// TestExpressionErrors ensures bogus constants and expressions are
+// reported.
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"store #1, 0xFFFF+1", "value 65536 is outside the range -32768..65535"},
+		{"store #1, -0x8001", "value -32769 is outside the range -32768..65535"},
+		{"cmp #1, 1-0x8002", "value -32769 is outside the range -32768..65535"},
+		{"store #1, 0x10000000000000000", "invalid number"},
+		{"store #1, end-0x8005\n:end", "value -32769 is outside the range -32768..65535"},
+		{"cmp #1, end+0xFFFF\n:end", "value 65539 is outside the range -32768..65535"},
+		{"jmp end-10\n:end", "value -7 is outside the range 0..65535"},
+		{"int 1-2", "value -1 is outside the range 0..65535"},
+		{"DB 256", "value 256 is outside the range -128..255"},
+		{"DB -129", "value -129 is outside the range -128..255"},
+		{"store #1, 1/(2-2)", "division by zero"},
+		{"store #1, 0x100000000*0x100000000", "test.in:1:22: error: value out of range"},
+		{"store #1, 0x7FFFFFFFFFFFFFFF+1", "test.in:1:29: error: value out of range"},
+		{"store #1, -0x7FFFFFFFFFFFFFFF-2", "test.in:1:30: error: value out of range"},
+		{"store #1, -(-0x7FFFFFFFFFFFFFFF-1)", "test.in:1:11: error: value out of range"},
+		{"store #1, end*0x100000000*0x100000000\n:end", "test.in:1:26: error: value out of range"},
+		{"store #1, (1+2", "expected next token to be )"},
+		{"store #1, 1+#2", "registers may not be used in expressions"},
+		{"store #1, 1+,", "expected a number, constant, or label, got COMMA"},
+		{".equ #1 3", "may not be a register"},
+		{".equ A 1\n.equ A 2", "constant 'A' is already defined at test.in:1:6"},
+		{".equ A 1\n:A", "label 'A' is already defined as a constant"},
+		{":A\n.equ A 1", "constant 'A' is already defined as a label"},
+		{"store #1, LATER\n.equ LATER 3", "test.in:1:11: error: constant 'LATER' is used before its definition at test.in:2:6"},
+	}
+
+	for _, test := range tests {
+		_, err := compile(t, test.input)
+		if err == nil {
+			t.Fatalf("expected error compiling %s", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got '%s'", test.error, err)
+		}
+	}
+}
+
+// TestMacroExpressions ensures macro arguments may be expressions.
+func TestMacroExpressions(t *testing.T) {
+	c, err := compile(t, `
+.macro load reg, value
+        store reg, value*2
+.endm
+        load #1, 1+2
+        load #2, (3)
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected, err := compile(t, `
+        store #1, 6
+        store #2, 6
+        exit
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(c.Output()) != string(expected.Output()) {
+		t.Fatalf("macro expansion mismatch:\n% X\n% X", c.Output(), expected.Output())
+	}
+}
+
+// TestListing ensures listings show the bytes generated for each line,
+// along with the labels.
+func TestListing(t *testing.T) {
+	c, err := compile(t, `# comment
+.macro twice reg
+        inc reg
+        inc reg
+.endm
+:start
+        store #1, "hello world"
+        twice #1
+        jmp start
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var out strings.Builder
+	err = c.Listing(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `                               # comment
+0000                           .macro twice reg
+                                       inc reg
+                                       inc reg
+                               .endm
+0000                           :start
+0000  30 01 0B 00 68 65 6C 6C          store #1, "hello world"
+0008  6F 20 77 6F 72 6C 64
+000F                                   twice #1
+000F  25 01
+0011  25 01
+0013  10 00 00                         jmp start
+
+Symbols:
+0000  start
+      defined at test.in:6:1, used at test.in:9:13
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected listing:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+// TestLabels ensures undefined and duplicate labels are errors, and that
+// unused labels are warned about.
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"jmp missing", "test.in:1:5: error: undefined label 'missing'"},
+		{":start\n store #1, start+missing", "test.in:2:18: error: undefined label 'missing'"},
+		{":a\n:a\n jmp a", "test.in:2:1: error: label 'a' is already defined at test.in:1:1"},
+	}
+
+	for _, test := range tests {
+		_, err := compile(t, test.input)
+		if err == nil {
+			t.Fatalf("expected error compiling %s", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got '%s'", test.error, err)
+		}
+	}
+
+	// A label at address zero is fine.
+	c, err := compile(t, ":start\n jmp start\n:unused\n exit")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	diags := c.Diagnostics()
+	if len(diags) != 1 || diags[0].String() != "test.in:3:1: warning: label 'unused' defined but never used" {
+		t.Fatalf("expected a single warning, got %v", diags)
+	}
+}
+
+// TestObject ensures relocatable objects record their exports, imports,
+// and relocations, and that linking them matches compiling the whole.
+func TestObject(t *testing.T) {
+	build := func(input string) (*Compiler, error) {
+		c := New(lexer.New(input))
+		c.SetFilename("test.in")
+		c.SetRelocatable(true)
+		return c, c.Compile()
+	}
+
+	main, err := build(`
+        call lib+1
+:self
+        jmp self
+        store #1, end-self
+:end
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	obj := main.Object()
+	if !reflect.DeepEqual(obj.Imports, []string{"lib"}) || len(obj.Exports) != 0 {
+		t.Fatalf("unexpected symbols: %v %v", obj.Imports, obj.Exports)
+	}
+	expected := []object.Relocation{
+		{Offset: 1, Size: 2, Symbol: "lib", Addend: 1},
+		{Offset: 4, Size: 2, Addend: 3},
+	}
+	if !reflect.DeepEqual(obj.Relocations, expected) {
+		t.Fatalf("unexpected relocations: %v", obj.Relocations)
+	}
+
+	lib, err := build(".global lib\n:lib\n nop\n ret\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(lib.Object().Exports, []object.Symbol{{Name: "lib", Address: 0}}) {
+		t.Fatalf("unexpected exports: %v", lib.Object().Exports)
+	}
+
+	// Bogus objects.
+	tests := []struct {
+		input string
+		error string
+	}{
+		{".global missing", "global label 'missing' is not defined"},
+		{"jmp a*2", "expression can't be relocated"},
+		{":a\n jmp a+a", "expression can't be relocated"},
+		{"jmp a+b", "expression can't be relocated"},
+	}
+	for _, test := range tests {
+		_, err := build(test.input)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got '%v'", test.error, err)
+		}
+	}
+}
+
+// TestEntry ensures the entry-point may be given via `.entry`.
+func TestEntry(t *testing.T) {
+	c, err := compile(t, `
+        exit
+:start
+        nop
+        .entry start
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.Program().Entry != 1 {
+		t.Fatalf("expected entry-point 1, got %d", c.Program().Entry)
+	}
+
+	tests := []struct {
+		input string
+		error string
+	}{
+		{".entry missing\nexit", "undefined label 'missing'"},
+		{".entry end\nexit\n:end", "entry-point 'end' is outside the program"},
+		{".entry a\n.entry a\n:a\nexit", "entry-point is already defined at test.in:1:8"},
+	}
+	for _, test := range tests {
+		_, err := compile(t, test.input)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got %v", test.error, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// This file contains the diagnostics our compiler generates.
+//
+// Rather than printing problems and terminating, the compiler records
+// each problem it finds, and continues processing the input.  Once
+// compilation has finished the caller receives the complete list, via
+// the error returned from `Compile`.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity describes how serious a diagnostic is.
+type Severity int
+
+const (
+	// Error diagnostics prevent a program from being compiled.
+	Error Severity = iota
+
+	// Warning diagnostics describe suspicious, but legal, input.
+	Warning
+)
+
+// String converts the given Severity to a string.
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// Diagnostic holds the details of a single problem found in a program.
+type Diagnostic struct {
+	// File is the name of the source file the problem was found in.
+	File string
+
+	// Line is the line-number of the problem, starting from 1.
+	//
+	// A value of zero means the position is unknown.
+	Line int
+
+	// Column is the column of the problem, starting from 1.
+	Column int
+
+	// Severity describes whether this is an error or a warning.
+	Severity Severity
+
+	// Message contains the human-readable description of the problem.
+	Message string
+}
+
+// String formats the diagnostic in the style used by gcc:
+//
+//    file:line:col: error: message
+//
+func (d Diagnostic) String() string {
+	pos := d.File
+	if pos == "" {
+		pos = "<input>"
+	}
+	if d.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", pos, d.Line, d.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s", pos, d.Severity, d.Message)
+}
+
+// Diagnostics is a list of problems, which may be used as an error.
+type Diagnostics []Diagnostic
+
+// Error implements the error interface, returning each diagnostic
+// upon a line of its own.
+func (d Diagnostics) Error() string {
+	lines := make([]string, len(d))
+	for i, diag := range d {
+		lines[i] = diag.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors returns true if any of the diagnostics are errors, rather
+// than warnings.
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,430 @@
+// This file contains the handling of expressions, which may be used
+// wherever an instruction expects a number or an address:
+//
+//     .equ MAX 10
+//
+//             store #1, BUFFER+4
+//             cmp #2, MAX*2-1
+//
+// Expressions are built from numbers, constants, and labels, combined
+// with `+`, `-`, `*`, `/`, and parentheses.  Constants are defined with
+// `.equ` (or `.define`), and are replaced by their value.
+//
+// Expressions which refer to labels can't be evaluated until we've seen
+// the whole program, so they're recorded as fixups and evaluated once
+// compilation has completed.
+
+package compiler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/skx/go.vm/token"
+)
+
+// expression is a node in the tree representing an expression.
+type expression struct {
+	// kind is token.INT for a number, token.IDENT for a label, or
+	// the type of the operator.
+	kind token.Type
+
+	// value holds the value of a number.
+	value int64
+
+	// unsigned is set for numbers too large for an int64, whose value
+	// holds them in two's complement form.
+	unsigned bool
+
+	// name holds the name of a label.
+	name string
+
+	// tok is the token which referred to a label, or of an operator.
+	tok token.Token
+
+	// left and right hold the operands of an operator.  Unary minus
+	// only has a left-operand.
+	left  *expression
+	right *expression
+}
+
+// fixup records an expression which must be written into our bytecode
+// once all labels are known.
+type fixup struct {
+	// expr is the expression to evaluate.
+	expr *expression
+
+	// size is the number of bytes to write: one, two, four, or eight.
+	size int
+
+	// signed is set if the value may be negative, in which case it is
+	// written in two's complement form.
+	signed bool
+
+	// tok is the first token of the expression, used when reporting
+	// problems.
+	tok token.Token
+}
+
+// constant holds a constant defined via `.equ` or `.define`.
+type constant struct {
+	// expr is the value of the constant.
+	expr *expression
+
+	// tok is the token which defined the constant.
+	tok token.Token
+}
+
+// isOperator returns true if the token-type is a binary operator.
+func isOperator(t token.Type) bool {
+	switch t {
+	case token.PLUS, token.MINUS, token.ASTERISK, token.SLASH:
+		return true
+	}
+	return false
+}
+
+// labels returns true if the expression refers to any label.
+func (e *expression) labels() bool {
+	if e == nil {
+		return false
+	}
+	if e.kind == token.IDENT {
+		return true
+	}
+	return e.left.labels() || e.right.labels()
+}
+
+// eval evaluates the expression, using the given function to find the
+// address of any labels.
+func (e *expression) eval(label func(e *expression) int64) (int64, error) {
+	switch e.kind {
+	case token.INT:
+		return e.value, nil
+	case token.IDENT:
+		return label(e), nil
+	}
+
+	a, err := e.left.eval(label)
+	if err != nil {
+		return 0, err
+	}
+	if e.right == nil {
+		// negation
+		val, ok := arith(token.MINUS, 0, a)
+		if !ok {
+			return 0, &evalError{tok: e.tok, msg: "value out of range"}
+		}
+		return val, nil
+	}
+	b, err := e.right.eval(label)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.kind {
+	case token.PLUS, token.MINUS, token.ASTERISK, token.SLASH:
+		if e.kind == token.SLASH && b == 0 {
+			return 0, &evalError{tok: e.tok, msg: "division by zero in expression"}
+		}
+		val, ok := arith(e.kind, a, b)
+		if !ok {
+			return 0, &evalError{tok: e.tok, msg: "value out of range"}
+		}
+		return val, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s in expression", e.kind)
+}
+
+// arith applies the given operator to a and b, returning false if the
+// result doesn't fit in an int64.  b must not be zero for division.
+func arith(op token.Type, a int64, b int64) (int64, bool) {
+	switch op {
+	case token.PLUS:
+		val := a + b
+		return val, !(b > 0 && val < a || b < 0 && val > a)
+	case token.MINUS:
+		val := a - b
+		return val, !(b > 0 && val > a || b < 0 && val < a)
+	case token.ASTERISK:
+		val := a * b
+		if a != 0 && (val/a != b || a == -1 && b == math.MinInt64) {
+			return 0, false
+		}
+		return val, true
+	case token.SLASH:
+		return a / b, !(a == math.MinInt64 && b == -1)
+	}
+	return 0, false
+}
+
+// evalError is a problem found when evaluating an expression, with the
+// token of the operator responsible.
+type evalError struct {
+	tok token.Token
+	msg string
+}
+
+// Error returns the description of the problem.
+func (e *evalError) Error() string {
+	return e.msg
+}
+
+// evalFailed reports the given error, found when evaluating the
+// expression which began with the given token.  The error is reported
+// against its operator, if known.
+func (p *Compiler) evalFailed(tok token.Token, err error) {
+	if e, ok := err.(*evalError); ok {
+		tok = e.tok
+	}
+	p.errorAt(tok, "%s", err.Error())
+}
+
+// parseExpression parses the expression starting at the current token.
+//
+// Upon return the current token is the last token of the expression.
+// If the expression is invalid an error is reported, and nil returned.
+func (p *Compiler) parseExpression() *expression {
+	left := p.parseTerm()
+	for left != nil && (p.peekTokenIs(token.PLUS) || p.peekTokenIs(token.MINUS)) {
+		p.nextToken()
+		op := p.curToken
+		p.nextToken()
+		right := p.parseTerm()
+		if right == nil {
+			return nil
+		}
+		left = &expression{kind: op.Type, left: left, right: right, tok: op}
+	}
+	return left
+}
+
+// parseTerm parses multiplication and division.
+func (p *Compiler) parseTerm() *expression {
+	left := p.parseUnary()
+	for left != nil && (p.peekTokenIs(token.ASTERISK) || p.peekTokenIs(token.SLASH)) {
+		p.nextToken()
+		op := p.curToken
+		p.nextToken()
+		right := p.parseUnary()
+		if right == nil {
+			return nil
+		}
+		left = &expression{kind: op.Type, left: left, right: right, tok: op}
+	}
+	return left
+}
+
+// parseUnary parses a negated value, or a primary.
+func (p *Compiler) parseUnary() *expression {
+	if p.curToken.Type == token.MINUS {
+		minus := p.curToken
+		p.nextToken()
+		val := p.parseUnary()
+		if val == nil {
+			return nil
+		}
+		return &expression{kind: token.MINUS, left: val, tok: minus}
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses numbers, constants, labels, and parenthesized
+// expressions.
+func (p *Compiler) parsePrimary() *expression {
+	tok := p.curToken
+
+	switch tok.Type {
+	case token.INT:
+		// Literals are never negative, unless they didn't fit.
+		value := p.getNumber(tok.Literal)
+		if value < 0 && p.width < 64 {
+			p.errorf("value %s does not fit in a %d-bit word", tok.Literal, p.width)
+			value = 0
+		}
+		return &expression{kind: token.INT, value: value, unsigned: value < 0}
+
+	case token.IDENT:
+		if p.isRegister(tok.Literal) {
+			p.errorf("registers may not be used in expressions: %s", tok.Literal)
+			return nil
+		}
+		if c, ok := p.constants[tok.Literal]; ok {
+			return c.expr
+		}
+		p.labelReference(tok)
+		return &expression{kind: token.IDENT, name: tok.Literal, tok: tok}
+
+	case token.LPAREN:
+		p.nextToken()
+		e := p.parseExpression()
+		if e == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return e
+	}
+
+	p.errorf("expected a number, constant, or label, got %s '%s'", tok.Type, tok.Literal)
+	return nil
+}
+
+// emitExpression parses the expression at the current token and writes
+// its value to our bytecode, using the given number of bytes.
+//
+// If the expression refers to labels a fixup is recorded, so that the
+// value may be written once the labels are known.
+func (p *Compiler) emitExpression(size int) {
+	p.emit(size, false)
+}
+
+// emitNumber is like emitExpression, but allows the value to be negative,
+// for the operands which hold numbers rather than addresses.
+func (p *Compiler) emitNumber(size int) {
+	p.emit(size, true)
+}
+
+// emit parses the expression at the current token, and writes its value.
+func (p *Compiler) emit(size int, signed bool) {
+	tok := p.curToken
+	e := p.parseExpression()
+	if e == nil {
+		return
+	}
+	p.write(e, tok, size, signed)
+}
+
+// emitImmediate writes an instruction which stores, or compares, the
+// given register with the value of the expression at the current token.
+//
+// The value must fit in a word, and is written using the smallest
+// encoding which holds it: op16 takes two bytes, holding 0x0000-0xFFFF,
+// or -32768 to -1 when words are 16 bits.  op32 takes four bytes, and
+// op64 eight, both of which the CPU sign-extends to fill a word.
+//
+// Expressions which refer to labels always use op16, as we don't know
+// their value until compilation has completed.
+func (p *Compiler) emitImmediate(reg byte, op16 int, op32 int, op64 int) {
+	tok := p.curToken
+	e := p.parseExpression()
+	if e == nil {
+		return
+	}
+
+	op, size := op16, 2
+	if !e.labels() && p.width > 16 {
+		value, err := e.eval(nil)
+		if err != nil {
+			p.evalFailed(tok, err)
+			return
+		}
+
+		if p.width < 64 && (value < -1<<uint(p.width-1) || value > 1<<uint(p.width)-1) {
+			p.errorAt(tok, "value %d does not fit in a %d-bit word", value, p.width)
+			return
+		}
+
+		switch {
+		case value >= 0 && value <= 0xFFFF:
+		case value >= math.MinInt32 && value <= math.MaxInt32 && !e.unsigned:
+			op, size = op32, 4
+		case p.width == 32:
+			// 0x80000000-0xFFFFFFFF sign-extend to the same word
+			op, size = op32, 4
+		default:
+			op, size = op64, 8
+		}
+	}
+
+	p.bytecode = append(p.bytecode, byte(op), reg)
+	p.write(e, tok, size, true)
+}
+
+// write writes the value of the given expression, which started at the
+// given token, to our bytecode using the given number of bytes.
+func (p *Compiler) write(e *expression, tok token.Token, size int, signed bool) {
+	addr := len(p.bytecode)
+	for i := 0; i < size; i++ {
+		p.bytecode = append(p.bytecode, byte(0))
+	}
+
+	f := &fixup{expr: e, size: size, signed: signed, tok: tok}
+	if e.labels() {
+		p.fixups[addr] = f
+		return
+	}
+	p.writeValue(addr, f, nil)
+}
+
+// writeValue evaluates the expression of the given fixup, and writes the
+// result at the given address within our bytecode.
+func (p *Compiler) writeValue(addr int, f *fixup, label func(e *expression) int64) {
+	size := f.size
+	value, err := f.expr.eval(label)
+	if err != nil {
+		p.evalFailed(f.tok, err)
+		return
+	}
+
+	// Four- and eight-byte values have already been chosen to fit.
+	if size <= 2 {
+		max := int64(0xFFFF)
+		if size == 1 {
+			max = 0xFF
+		}
+		min := int64(0)
+		if f.signed {
+			min = -(max + 1) / 2
+		}
+		if value < min || value > max {
+			p.errorAt(f.tok, "value %d is outside the range %d..%d", value, min, max)
+			return
+		}
+	}
+
+	// Negative values are written in two's complement form, and
+	// all values are little-endian.
+	for i := 0; i < size; i++ {
+		p.bytecode[addr+i] = byte(value >> uint(8*i))
+	}
+}
+
+// constantDefinition handles `.equ NAME value` and `.define NAME value`.
+func (p *Compiler) constantDefinition() {
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	name := p.curToken
+
+	if p.isRegister(name.Literal) {
+		p.errorf("constant '%s' may not be a register", name.Literal)
+		return
+	}
+	if prev, ok := p.constants[name.Literal]; ok {
+		p.errorf("constant '%s' is already defined at %s", name.Literal, p.position(prev.tok))
+		return
+	}
+	if _, ok := p.labels[name.Literal]; ok {
+		p.errorf("constant '%s' is already defined as a label", name.Literal)
+		return
+	}
+
+	// The comma is optional.
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+	}
+	p.nextToken()
+
+	e := p.parseExpression()
+	if e == nil {
+		return
+	}
+	p.constants[name.Literal] = &constant{expr: e, tok: name}
+}
+
+// position returns the location of the given token, for use in messages.
+func (p *Compiler) position(tok token.Token) string {
+	return fmt.Sprintf("%s:%d:%d", p.fileOf(tok.Pos), tok.Pos.Line, tok.Pos.Column)
+}
@@ -0,0 +1,130 @@
+// This file contains the implementation of the `.include` directive,
+// which allows one source file to use the contents of another:
+//
+//     .include "lib/box.in"
+//
+// The named file is searched for relative to the directory containing
+// the file which includes it, and then within each of the include-paths
+// which have been configured, in order.
+//
+// The included file is lexed in full, and its tokens are inserted into
+// our stream at the point of the directive.  Each token records the file
+// it came from, so diagnostics refer to the right place.
+
+package compiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/token"
+)
+
+// AddIncludePath adds a directory to the list which will be searched
+// for files named by `.include`.
+func (p *Compiler) AddIncludePath(dir string) {
+	p.paths = append(p.paths, dir)
+}
+
+// includeFile handles the `.include` directive.
+func (p *Compiler) includeFile() {
+	if !p.expectPeek(token.STRING) {
+		return
+	}
+	name := p.curToken.Literal
+	parent := p.fileOf(p.curToken.Pos)
+
+	path, err := p.findInclude(name, parent)
+	if err != nil {
+		p.errorf("%s", err.Error())
+		return
+	}
+
+	// Look for cycles, by walking up the chain of files which
+	// lead to this one.
+	abs := absolute(path)
+	chain := []string{path}
+	for file := absolute(parent); file != ""; file = p.includedBy[file].parent {
+		chain = append([]string{p.nameOf(file)}, chain...)
+		if file == abs {
+			p.errorf("include cycle: %s", strings.Join(chain, " -> "))
+			return
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		p.errorf("failed to read %s - %s", path, err.Error())
+		return
+	}
+	p.includedBy[abs] = inclusion{name: path, parent: absolute(parent)}
+	p.sources[path] = &source{lines: splitLines(string(data)), parent: parent}
+
+	// Lex the file, recording the name of the file against each token.
+	var included []token.Token
+	l := lexer.New(string(data))
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		tok.Pos.File = path
+		included = append(included, tok)
+	}
+
+	p.insertTokens(included)
+}
+
+// inclusion records how a file came to be included.
+type inclusion struct {
+	// name is the path the file was included via.
+	name string
+
+	// parent is the absolute path of the file which included it.
+	parent string
+}
+
+// nameOf returns the name of the file with the given absolute path, as
+// it was originally given to us.
+func (p *Compiler) nameOf(abs string) string {
+	if inc, ok := p.includedBy[abs]; ok {
+		return inc.name
+	}
+	if p.filename != "" {
+		return p.filename
+	}
+	return abs
+}
+
+// findInclude locates the named file, returning its path.
+func (p *Compiler) findInclude(name string, parent string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+
+	dirs := append([]string{filepath.Dir(parent)}, p.paths...)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find include file %s", name)
+}
+
+// absolute returns the absolute version of the given path, which we use
+// to identify files.
+func absolute(path string) string {
+	if path == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
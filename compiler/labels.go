@@ -0,0 +1,154 @@
+// This file contains the book-keeping for labels.
+//
+// We record where each label is defined, and every place it is used,
+// so that once the whole program has been seen we can report labels
+// which are used but never defined, and those which are defined but
+// never used.
+
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/go.vm/token"
+)
+
+// labelDefinition handles a label, which records the current address.
+func (p *Compiler) labelDefinition() {
+	// Remove the ":" prefix from the label
+	label := strings.TrimPrefix(p.curToken.Literal, ":")
+
+	if _, ok := p.constants[label]; ok {
+		p.errorf("label '%s' is already defined as a constant", label)
+		return
+	}
+	if prev, ok := p.definitions[label]; ok {
+		p.errorf("label '%s' is already defined at %s", label, p.position(prev))
+		return
+	}
+
+	// The label points to the current point in our bytecode
+	p.labels[label] = len(p.bytecode)
+	p.definitions[label] = p.curToken
+}
+
+// labelReference records a use of the label named by the given token.
+func (p *Compiler) labelReference(tok token.Token) {
+	p.references = append(p.references, tok)
+}
+
+// entryDeclaration handles `.entry LABEL`, which sets the address at
+// which execution of the program begins, rather than its start.
+func (p *Compiler) entryDeclaration() {
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	tok := p.curToken
+	if p.entry != nil {
+		p.errorf("entry-point is already defined at %s", p.position(*p.entry))
+		return
+	}
+	if p.relocatable {
+		p.errorf("an object may not have an entry-point")
+		return
+	}
+	p.entry = &tok
+	p.labelReference(tok)
+}
+
+// checkEntry reports an entry-point which is outside the program, once
+// the labels are known.
+func (p *Compiler) checkEntry() {
+	if p.entry == nil {
+		return
+	}
+	addr, ok := p.labels[p.entry.Literal]
+	if ok && addr >= len(p.bytecode) {
+		p.errorAt(*p.entry, "entry-point '%s' is outside the program", p.entry.Literal)
+	}
+}
+
+// checkLabels reports labels which were used without being defined,
+// and labels which were defined but never used.
+//
+// When generating an object undefined labels are permitted, as they
+// will be imported from another object when linking.
+//
+// Constants are substituted as they are parsed, so a name which was
+// only defined as a constant later in the program is reported too.
+func (p *Compiler) checkLabels() {
+	used := make(map[string]bool)
+	for _, ref := range p.references {
+		used[ref.Literal] = true
+		if c, ok := p.constants[ref.Literal]; ok {
+			p.errorAt(ref, "constant '%s' is used before its definition at %s", ref.Literal, p.position(c.tok))
+			continue
+		}
+		if _, ok := p.definitions[ref.Literal]; !ok && !p.relocatable {
+			p.errorAt(ref, "undefined label '%s'", ref.Literal)
+		}
+	}
+
+	// Exported labels are used by other objects.
+	for _, name := range p.globalNames() {
+		used[name] = true
+		if _, ok := p.definitions[name]; !ok {
+			p.errorAt(p.globals[name], "global label '%s' is not defined", name)
+		}
+	}
+
+	for _, name := range p.sortedLabels() {
+		if !used[name] {
+			p.warnAt(p.definitions[name], "label '%s' defined but never used", name)
+		}
+	}
+}
+
+// sortedLabels returns the names of all labels, in order of their
+// address.
+func (p *Compiler) sortedLabels() []string {
+	names := make([]string, 0, len(p.labels))
+	for name := range p.labels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.labels[names[i]], p.labels[names[j]]
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// crossReference describes where the named label was defined, and
+// each place it was used.
+func (p *Compiler) crossReference(name string) string {
+	out := fmt.Sprintf("defined at %s", p.position(p.definitions[name]))
+
+	var refs []string
+	for _, ref := range p.references {
+		if ref.Literal == name {
+			refs = append(refs, p.position(ref))
+		}
+	}
+	if len(refs) == 0 {
+		return out + ", never used"
+	}
+	return out + ", used at " + strings.Join(refs, ", ")
+}
+
+// resolved returns true if every label the expression refers to has
+// been defined.
+func (p *Compiler) resolved(e *expression) bool {
+	if e == nil {
+		return true
+	}
+	if e.kind == token.IDENT {
+		_, ok := p.definitions[e.name]
+		return ok
+	}
+	return p.resolved(e.left) && p.resolved(e.right)
+}
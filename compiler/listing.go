@@ -0,0 +1,207 @@
+// This file contains the generation of listings, which show the source
+// of a program alongside the bytecode generated for each line:
+//
+//     0000                           .equ WIDTH 10
+//     0000  01 01 14 00              store #1, WIDTH*2
+//     0004  60 01 02                 peek #1, #2
+//
+// The listing ends with a table of every label, and its address.
+
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// bytesPerRow is the number of bytes shown upon each row of a listing.
+const bytesPerRow = 8
+
+// source holds the text of an included file.
+type source struct {
+	// lines holds the text of the file, split into lines.
+	lines []string
+
+	// parent is the name of the file which included this one.
+	parent string
+}
+
+// splitLines splits the given text into lines.
+func splitLines(text string) []string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// WriteListing writes a listing of the compiled program to the given
+// file.
+func (p *Compiler) WriteListing(output string) error {
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error writing listing %s - %s", output, err.Error())
+	}
+
+	err = p.Listing(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Listing writes a listing of the compiled program to the given writer.
+//
+// There is one row for each line of source, showing the address and
+// bytes generated for it.  Lines which generate more bytes than fit upon
+// a row, or which expand to several instructions, are continued upon the
+// following rows.
+func (p *Compiler) Listing(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	sources := make(map[string][]string)
+	sources[p.filename] = splitLines(p.l.Input())
+	for name, src := range p.sources {
+		sources[name] = src.lines
+	}
+
+	// The number of lines we've listed from each file, and the file
+	// we're currently listing.
+	listed := make(map[string]int)
+	current := p.filename
+
+	// row writes a single row of the listing.
+	row := func(addr int, code []byte, text string) {
+		hex := make([]string, len(code))
+		for i, b := range code {
+			hex[i] = fmt.Sprintf("%02X", b)
+		}
+
+		prefix := "    "
+		if addr >= 0 {
+			prefix = fmt.Sprintf("%04X", addr)
+		}
+		line := fmt.Sprintf("%s  %-*s  %s", prefix, bytesPerRow*3-1, strings.Join(hex, " "), text)
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+
+	// code writes the bytes at the given address, along with the text
+	// of the first row.
+	code := func(addr int, length int, text string) {
+		for {
+			n := length
+			if n > bytesPerRow {
+				n = bytesPerRow
+			}
+			row(addr, p.bytecode[addr:addr+n], text)
+
+			addr += n
+			length -= n
+			text = ""
+			if length <= 0 {
+				return
+			}
+		}
+	}
+
+	// upto lists the lines of the given file which precede the
+	// given line.
+	upto := func(file string, line int) {
+		if file != current {
+			fmt.Fprintf(out, "==> %s <==\n", file)
+			current = file
+		}
+		lines := sources[file]
+		for listed[file] < line-1 && listed[file] < len(lines) {
+			row(-1, nil, lines[listed[file]])
+			listed[file]++
+		}
+	}
+
+	// finish lists the remainder of any files which were included
+	// by the given one, deepest first, as we've now returned from them.
+	finish := func(file string) {
+		var done []string
+		for name := range sources {
+			started := listed[name] > 0 && listed[name] < len(sources[name])
+			if name != file && started && p.includedFrom(name, file) {
+				done = append(done, name)
+			}
+		}
+		sort.Slice(done, func(i, j int) bool {
+			a, b := p.depth(done[i]), p.depth(done[j])
+			if a != b {
+				return a > b
+			}
+			return done[i] < done[j]
+		})
+		for _, name := range done {
+			upto(name, len(sources[name])+1)
+		}
+	}
+
+	for _, line := range p.lines {
+
+		// A file we've already listed is being included again.
+		if line.File != current && listed[line.File] >= line.Line {
+			listed[line.File] = 0
+		}
+
+		// Further code from a line we've already listed.
+		if listed[line.File] >= line.Line {
+			if line.Length > 0 {
+				code(line.Address, line.Length, "")
+			}
+			continue
+		}
+
+		finish(line.File)
+		upto(line.File, line.Line)
+
+		text := ""
+		if lines := sources[line.File]; line.Line <= len(lines) {
+			text = lines[line.Line-1]
+		}
+		code(line.Address, line.Length, text)
+		listed[line.File] = line.Line
+	}
+
+	// List the remainder of every file, ending with the main one.
+	finish(p.filename)
+	upto(p.filename, len(sources[p.filename])+1)
+
+	// Finally show the labels, in order of their address, along with
+	// where they were defined and used.
+	fmt.Fprintf(out, "\nSymbols:\n")
+	for _, name := range p.sortedLabels() {
+		fmt.Fprintf(out, "%04X  %s\n", p.labels[name], name)
+		fmt.Fprintf(out, "      %s\n", p.crossReference(name))
+	}
+
+	return out.Flush()
+}
+
+// includedFrom returns true if the named file was included, directly or
+// otherwise, from the given file.
+func (p *Compiler) includedFrom(name string, file string) bool {
+	for src, ok := p.sources[name]; ok; src, ok = p.sources[src.parent] {
+		if src.parent == file {
+			return true
+		}
+	}
+	return false
+}
+
+// depth returns the number of includes which lead to the named file.
+func (p *Compiler) depth(name string) int {
+	depth := 0
+	for src, ok := p.sources[name]; ok; src, ok = p.sources[src.parent] {
+		depth++
+	}
+	return depth
+}
@@ -0,0 +1,205 @@
+// This file contains the implementation of our macros.
+//
+// A macro is defined with `.macro`, giving it a name and an optional
+// list of parameters, and its body continues until `.endm`:
+//
+//     .macro print_reg reg, tmp
+//             print_int reg
+//             store tmp, "\n"
+//             print_str tmp
+//     .endm
+//
+// Using the name of a macro, followed by its arguments, inserts the body
+// in place with each parameter replaced by the matching argument:
+//
+//             print_reg #1, #2
+//
+// Labels defined inside a macro body are local to each expansion, so a
+// macro containing a loop may be used more than once.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/go.vm/token"
+)
+
+// maxExpansions is the number of macro-expansions we allow, which stops
+// recursive macros from expanding forever.
+const maxExpansions = 0xFFFF
+
+// macro holds a single macro definition.
+type macro struct {
+	// name of the macro.
+	name string
+
+	// params holds the names of the parameters.
+	params []string
+
+	// body holds the tokens making up the body of the macro.
+	body []token.Token
+
+	// labels holds the names of labels defined within the body.
+	labels map[string]bool
+}
+
+// macroDefinition handles the definition of a new macro.
+func (p *Compiler) macroDefinition() {
+	start := p.curToken
+
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+
+	m := &macro{name: p.curToken.Literal, labels: make(map[string]bool)}
+	if _, ok := p.macros[m.name]; ok {
+		p.errorf("macro '%s' is already defined", m.name)
+		return
+	}
+
+	// Parameters are listed upon the same line as the name.
+	line := p.curToken.Pos.Line
+	for p.peekTokenIs(token.IDENT) && p.peekToken.Pos.Line == line {
+		p.nextToken()
+		if p.isRegister(p.curToken.Literal) {
+			p.errorf("macro parameter '%s' may not be a register", p.curToken.Literal)
+			return
+		}
+		m.params = append(m.params, p.curToken.Literal)
+
+		if p.peekTokenIs(token.COMMA) && p.peekToken.Pos.Line == line {
+			p.nextToken()
+		}
+	}
+
+	// Now collect the body.
+	for {
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case token.EOF:
+			p.errorAt(start, "macro '%s' has no matching .endm", m.name)
+			return
+		case token.MACRO:
+			p.errorf("macros may not be defined within macro '%s'", m.name)
+			return
+		case token.ENDM:
+			p.macros[m.name] = m
+			return
+		case token.LABEL:
+			m.labels[strings.TrimPrefix(p.curToken.Literal, ":")] = true
+		}
+
+		m.body = append(m.body, p.curToken)
+	}
+}
+
+// macroExpansion handles the use of a macro, by inserting its body
+// into our token-stream.
+func (p *Compiler) macroExpansion() {
+	use := p.curToken
+
+	m, ok := p.macros[use.Literal]
+	if !ok {
+		p.errorf("unexpected %s '%s'", use.Type, use.Literal)
+		return
+	}
+
+	p.expansions++
+	if p.expansions > maxExpansions {
+		p.errorf("too many macro expansions, is '%s' recursive?", m.name)
+		return
+	}
+
+	// Collect the arguments, which are comma-separated.
+	args := make(map[string][]token.Token)
+	for i, param := range m.params {
+		if i > 0 && !p.expectPeek(token.COMMA) {
+			return
+		}
+		arg, ok := p.macroArgument(m, param)
+		if !ok {
+			return
+		}
+		args[param] = arg
+	}
+
+	// Local labels are given a suffix unique to this expansion.
+	local := func(name string) string {
+		return fmt.Sprintf("%s@%d", name, p.expansions)
+	}
+
+	// Build up the expansion.
+	var expanded []token.Token
+	for _, tok := range m.body {
+		replacement := []token.Token{tok}
+
+		switch tok.Type {
+		case token.IDENT:
+			if arg, ok := args[tok.Literal]; ok {
+				replacement = arg
+			} else if m.labels[tok.Literal] {
+				replacement[0].Literal = local(tok.Literal)
+			}
+		case token.LABEL:
+			replacement[0].Literal = ":" + local(strings.TrimPrefix(tok.Literal, ":"))
+		}
+
+		// The expansion is reported as coming from the use of the
+		// macro, rather than its definition.
+		for _, tok := range replacement {
+			tok.Pos = use.Pos
+			expanded = append(expanded, tok)
+		}
+	}
+
+	p.insertTokens(expanded)
+}
+
+// macroArgument reads a single argument for the given parameter of a
+// macro.
+//
+// An argument is usually a single token, but it may also be an
+// expression, in which case it is wrapped in parenthesis so that it
+// is evaluated as a unit wherever it is used.
+func (p *Compiler) macroArgument(m *macro, param string) ([]token.Token, bool) {
+	var arg []token.Token
+	depth := 0
+
+	for {
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case token.IDENT, token.INT, token.STRING,
+			token.PLUS, token.MINUS, token.ASTERISK, token.SLASH:
+			// part of the argument
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			depth--
+		default:
+			p.errorf("expected argument '%s' for macro '%s', got %s", param, m.name, p.curToken.Type)
+			return nil, false
+		}
+		arg = append(arg, p.curToken)
+
+		// Keep reading while the expression is incomplete.
+		if depth > 0 || isOperator(p.curToken.Type) || p.curToken.Type == token.LPAREN {
+			continue
+		}
+		if isOperator(p.peekToken.Type) {
+			continue
+		}
+		break
+	}
+
+	if len(arg) == 1 {
+		return arg, true
+	}
+
+	lparen := token.Token{Type: token.LPAREN, Literal: "("}
+	rparen := token.Token{Type: token.RPAREN, Literal: ")"}
+	return append(append([]token.Token{lparen}, arg...), rparen), true
+}
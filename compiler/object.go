@@ -0,0 +1,199 @@
+// This file contains the generation of relocatable objects, which may
+// be linked together with others to produce a complete program.
+//
+// Labels are exported for use by other objects via `.global`:
+//
+//     .global print_box
+//     :print_box
+//             ...
+//
+// When generating an object, labels which aren't defined are assumed to
+// be imported from another object.  Each expression which refers to a
+// label results in a relocation, rather than being written directly, as
+// the final address of the label isn't known until the objects are
+// linked.
+
+package compiler
+
+import (
+	"math"
+	"sort"
+
+	"github.com/skx/go.vm/object"
+	"github.com/skx/go.vm/token"
+)
+
+// SetRelocatable configures the compiler to generate a relocatable
+// object, via `Object`, rather than a complete program.
+func (p *Compiler) SetRelocatable(enabled bool) {
+	p.relocatable = enabled
+}
+
+// Object returns the relocatable object for the compiled program.
+func (p *Compiler) Object() *object.Object {
+	obj := &object.Object{
+		Code:        p.bytecode,
+		Exports:     []object.Symbol{},
+		Imports:     []string{},
+		Relocations: p.relocations,
+	}
+	if obj.Relocations == nil {
+		obj.Relocations = []object.Relocation{}
+	}
+
+	for _, name := range p.globalNames() {
+		obj.Exports = append(obj.Exports, object.Symbol{Name: name, Address: p.labels[name]})
+	}
+
+	seen := make(map[string]bool)
+	for _, ref := range p.references {
+		if _, ok := p.definitions[ref.Literal]; !ok && !seen[ref.Literal] {
+			seen[ref.Literal] = true
+			obj.Imports = append(obj.Imports, ref.Literal)
+		}
+	}
+	sort.Strings(obj.Imports)
+
+	return obj
+}
+
+// globalDeclaration handles `.global NAME`, which exports the named
+// label.
+func (p *Compiler) globalDeclaration() {
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	if p.isRegister(p.curToken.Literal) {
+		p.errorf("global label '%s' may not be a register", p.curToken.Literal)
+		return
+	}
+	p.globals[p.curToken.Literal] = p.curToken
+}
+
+// globalNames returns the names of the exported labels, sorted.
+func (p *Compiler) globalNames() []string {
+	names := make([]string, 0, len(p.globals))
+	for name := range p.globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// relocate records a relocation for the given fixup.
+//
+// Only expressions of the form `label + constant` may be relocated, as
+// the linker can only add an address to the value we record.  If the
+// expression doesn't depend upon any address it is written directly.
+func (p *Compiler) relocate(addr int, f *fixup) {
+	value, labels, ok := f.expr.linear()
+	if !ok {
+		p.errorAt(f.tok, "expression can't be relocated")
+		return
+	}
+
+	// Local labels contribute their address, relative to the start of
+	// this object, and imported ones are left to the linker.
+	local := int64(0)
+	var imports []string
+	for name, scale := range labels {
+		if scale == 0 {
+			continue
+		}
+		if _, ok := p.definitions[name]; ok {
+			value += int64(p.labels[name]) * scale
+			local += scale
+		} else if scale == 1 {
+			imports = append(imports, name)
+		} else {
+			p.errorAt(f.tok, "expression can't be relocated")
+			return
+		}
+	}
+
+	if len(imports) == 0 && local == 0 {
+		p.writeValue(addr, f, func(e *expression) int64 { return int64(p.labels[e.name]) })
+		return
+	}
+
+	// Addends are recorded as an int, which may only hold 32 bits.
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		p.errorAt(f.tok, "expression can't be relocated")
+		return
+	}
+
+	r := object.Relocation{Offset: addr, Size: f.size, Addend: int(value)}
+	switch {
+	case len(imports) == 0 && local == 1:
+		// relative to the start of this object
+	case len(imports) == 1 && local == 0:
+		r.Symbol = imports[0]
+	default:
+		p.errorAt(f.tok, "expression can't be relocated")
+		return
+	}
+	p.relocations = append(p.relocations, r)
+}
+
+// linear returns the expression as a constant, plus the sum of each
+// label multiplied by a scale.  If the expression can't be represented
+// that way, because it multiplies or divides labels or overflows, it
+// returns false.
+func (e *expression) linear() (int64, map[string]int64, bool) {
+	switch e.kind {
+	case token.INT:
+		return e.value, map[string]int64{}, true
+	case token.IDENT:
+		return 0, map[string]int64{e.name: 1}, true
+	}
+
+	a, la, ok := e.left.linear()
+	if !ok {
+		return 0, nil, false
+	}
+	if e.right == nil {
+		val, ok := arith(token.MINUS, 0, a)
+		return val, scale(la, -1), ok
+	}
+	b, lb, ok := e.right.linear()
+	if !ok {
+		return 0, nil, false
+	}
+
+	switch e.kind {
+	case token.PLUS:
+		for name, n := range lb {
+			la[name] += n
+		}
+		val, ok := arith(e.kind, a, b)
+		return val, la, ok
+	case token.MINUS:
+		for name, n := range lb {
+			la[name] -= n
+		}
+		val, ok := arith(e.kind, a, b)
+		return val, la, ok
+	case token.ASTERISK:
+		val, ok := arith(e.kind, a, b)
+		if len(la) == 0 {
+			return val, scale(lb, a), ok
+		}
+		if len(lb) == 0 {
+			return val, scale(la, b), ok
+		}
+	case token.SLASH:
+		if len(la) == 0 && len(lb) == 0 && b != 0 {
+			val, ok := arith(e.kind, a, b)
+			return val, la, ok
+		}
+	}
+	return 0, nil, false
+}
+
+// scale multiplies each label of a linear expression by the given value.
+func scale(labels map[string]int64, n int64) map[string]int64 {
+	for name := range labels {
+		labels[name] *= n
+	}
+	return labels
+}
@@ -0,0 +1,251 @@
+// This file contains the helpers for our arithmetic, and logical,
+// instructions.
+//
+// Integers are held in words of 16 bits by default, or 32 or 64 bits if
+// selected via SetWordSize.  Arithmetic is performed modulo the size of
+// a word, so with 16-bit words `add` wraps around from 0xFFFF to zero and
+// `sub` wraps around from zero to 0xFFFF, and each instruction updates the
+// flags to describe its result:
+//
+//   z - the result was zero.
+//   c - an unsigned carry, or borrow, occurred.
+//   o - a signed overflow occurred, treating the values as two's
+//       complement numbers.
+//   s - the sign bit, the top bit of the word, of the result is set.
+//
+// Comparisons set the flags as a subtraction would, without storing the
+// result, so following `cmp` the C-flag is set if the first value was
+// less than the second.  Strings are ordered lexicographically.  This
+// allows `jmplt`, `jmple`, `jmpgt`, and `jmpge` to test the result.
+//
+// Earlier releases instead saturated results to the range 0x0000-0xFFFF,
+// and only updated the zero-flag within `sub`, `inc`, and `dec`.  That
+// behaviour may be restored, for existing programs, via SetSaturating.
+
+package cpu
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// SetSaturating selects saturating arithmetic, where results are clamped
+// to the range of a word, 0x0000-0xFFFF by default, rather than wrapping
+// around.
+//
+// In this mode only `sub`, `inc`, and `dec` update the flags, and then
+// only the zero-flag, exactly as in earlier releases.  `inc` and `dec`
+// set or clear it to match their result, but `sub` only ever sets it,
+// when the result is clamped to zero.
+func (c *CPU) SetSaturating(saturating bool) {
+	c.saturating = saturating
+}
+
+// SetWordSize sets the number of bits in our integers, which must be 16,
+// the default, 32, or 64.
+//
+// The value of each register holding an integer is truncated to fit.
+// Note that Register.GetInt truncates values which don't fit in an int,
+// Register.GetWord returns them unchanged.
+func (c *CPU) SetWordSize(size int) error {
+	switch size {
+	case 16, 32, 64:
+	default:
+		return fmt.Errorf("unsupported word size %d, expected 16, 32, or 64", size)
+	}
+	c.width = uint(size)
+
+	for i := range c.regs {
+		if c.regs[i].kind == intKind {
+			c.regs[i].SetWord(c.regs[i].i & c.mask())
+		}
+	}
+	return nil
+}
+
+// maxInt is the largest value an int may hold.
+const maxInt = int(^uint(0) >> 1)
+
+// mask returns a word with every bit set, which is the largest unsigned
+// value we can hold.
+func (c *CPU) mask() uint64 {
+	return ^uint64(0) >> (64 - c.width)
+}
+
+// top returns a word with only the sign bit set.
+func (c *CPU) top() uint64 {
+	return 1 << (c.width - 1)
+}
+
+// word returns the integer contents of the given register.
+func (c *CPU) word(reg int) (uint64, error) {
+	return c.regs[reg].GetWord()
+}
+
+// words returns the integer contents of the given pair of registers.
+func (c *CPU) words(a int, b int) (uint64, uint64, error) {
+	aVal, err := c.word(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	bVal, err := c.word(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return aVal, bVal, nil
+}
+
+// immediate converts the given number, an operand of an instruction, to
+// a word - which is an error if it does not fit within one.
+func (c *CPU) immediate(val int64) (uint64, error) {
+	if c.width < 64 && (val < -int64(c.top()) || val > int64(c.mask())) {
+		return 0, fmt.Errorf("value %d does not fit in a %d-bit word", val, c.width)
+	}
+	return uint64(val) & c.mask(), nil
+}
+
+// setResult stores the result of an arithmetic, or logical, operation in
+// the given register, wrapping it around, and sets the flags to match.
+func (c *CPU) setResult(reg int, val uint64, carry bool, overflow bool) {
+	val &= c.mask()
+
+	c.flags.z = (val == 0)
+	c.flags.s = (val&c.top() != 0)
+	c.flags.c = carry
+	c.flags.o = overflow
+
+	c.setWord(reg, val)
+}
+
+// add returns the sum of a and b, wrapped around, and true if it carried
+// out of the word.
+func (c *CPU) add(a uint64, b uint64) (uint64, bool) {
+	r, carry := bits.Add64(a, b, 0)
+	return r & c.mask(), carry != 0 || r > c.mask()
+}
+
+// mul returns the product of a and b, wrapped around, and true if it
+// could not be held in the word.
+func (c *CPU) mul(a uint64, b uint64) (uint64, bool) {
+	hi, lo := bits.Mul64(a, b)
+	return lo & c.mask(), hi != 0 || lo > c.mask()
+}
+
+// addOverflows returns true if the addition of a and b, giving r, is a
+// signed overflow - that is the operands have the same sign, which
+// differs from the sign of the result.
+func (c *CPU) addOverflows(a uint64, b uint64, r uint64) bool {
+	return (a^r)&(b^r)&c.top() != 0
+}
+
+// subOverflows returns true if the subtraction of b from a, giving r, is
+// a signed overflow - that is the operands have differing signs, and the
+// sign of the result differs from that of a.
+func (c *CPU) subOverflows(a uint64, b uint64, r uint64) bool {
+	return (a^b)&(a^r)&c.top() != 0
+}
+
+// mulOverflows returns true if the product of a and b, treated as signed
+// values, cannot be held in the word.
+func (c *CPU) mulOverflows(a uint64, b uint64) bool {
+	x, y := c.integer(a), c.integer(b)
+	if c.width < 64 {
+		r := x * y
+		return r < -int64(c.top()) || r > int64(c.top()-1)
+	}
+
+	// The product of 64-bit values may itself overflow, which we
+	// detect by reversing the multiplication.  That doesn't work for
+	// the most negative value multiplied by -1.
+	if x == 0 || y == 0 {
+		return false
+	}
+	if (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+		return true
+	}
+	return (x*y)/y != x
+}
+
+// compareInts sets the flags as if b had been subtracted from a, without
+// storing the result.
+func (c *CPU) compareInts(a uint64, b uint64) {
+	r := (a - b) & c.mask()
+
+	c.flags.z = (a == b)
+	c.flags.c = (a < b)
+	c.flags.o = c.subOverflows(a, b, r)
+	c.flags.s = (r&c.top() != 0)
+}
+
+// compareStrings sets the flags to describe the lexicographic order of
+// the given strings, such that a comparison of them may be tested in the
+// same way as one between integers.
+func (c *CPU) compareStrings(a string, b string) {
+	c.flags = Flags{z: a == b, c: a < b, s: a < b}
+}
+
+// less returns true if the last comparison found its first value to be
+// less than its second.
+//
+// In signed mode that is the case if the result of the subtraction was
+// negative, unless it overflowed - in which case the reverse is true.
+func (c *CPU) less() bool {
+	if c.signed {
+		return c.flags.s != c.flags.o
+	}
+	return c.flags.c
+}
+
+// SetSigned selects whether integers are treated as signed values, in
+// two's complement form, rather than unsigned.
+//
+// Registers always hold unsigned words, 0x0000-0xFFFF by default, but in
+// signed mode 0x8000-0xFFFF represent -32768 to -1.  This changes how they
+// are printed, and converted to and from strings, and changes `div`,
+// `shr`, the range given to `random`, and the ordered jumps, which follow
+// signed comparisons.  Other arithmetic gives the same result in either
+// mode.
+func (c *CPU) SetSigned(signed bool) {
+	c.signed = signed
+}
+
+// integer returns the given word as a signed number, which is negative if
+// the sign bit is set.
+func (c *CPU) integer(val uint64) int64 {
+	shift := 64 - c.width
+	return int64(val<<shift) >> shift
+}
+
+// decimal returns the given word as a decimal string, which is negative
+// in signed mode if the sign bit is set.
+func (c *CPU) decimal(val uint64) string {
+	if c.signed {
+		return strconv.FormatInt(c.integer(val), 10)
+	}
+	return strconv.FormatUint(val, 10)
+}
+
+// setNumber stores the given number in the given register, clamping it to
+// the range we can represent, and storing negative numbers in two's
+// complement form if we're in signed mode.
+func (c *CPU) setNumber(reg int, val int64) {
+	if c.signed {
+		max := int64(c.top() - 1)
+		if val < -max-1 {
+			val = -max - 1
+		} else if val > max {
+			val = max
+		}
+		c.setWord(reg, uint64(val)&c.mask())
+		return
+	}
+
+	if val < 0 {
+		val = 0
+	} else if uint64(val) > c.mask() {
+		val = int64(c.mask())
+	}
+	c.setWord(reg, uint64(val))
+}
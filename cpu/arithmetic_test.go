@@ -0,0 +1,706 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// calculate runs the given three-register instruction against the given
+// values, returning the result.
+func calculate(t *testing.T, c *CPU, op int, a int, b int) int {
+	t.Helper()
+
+	c.LoadBytes([]byte{byte(op), 0x03, 0x01, 0x02, byte(opcode.EXIT)})
+	c.regs[1].SetInt(a)
+	c.regs[2].SetInt(b)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	val, err := c.regs[3].GetInt()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return val
+}
+
+// flags describes the flags, as a string, for comparisons.
+func flags(c *CPU) string {
+	return fmt.Sprintf("z=%t c=%t o=%t s=%t", c.flags.z, c.flags.c, c.flags.o, c.flags.s)
+}
+
+// TestWrapAround tests that arithmetic wraps around, and sets the flags.
+func TestWrapAround(t *testing.T) {
+
+	type TestCase struct {
+		op     int
+		a      int
+		b      int
+		result int
+		flags  string
+	}
+
+	tests := []TestCase{
+		{opcode.ADD_OP, 1, 2, 3, "z=false c=false o=false s=false"},
+		{opcode.ADD_OP, 0xFFFF, 1, 0, "z=true c=true o=false s=false"},
+		{opcode.ADD_OP, 0xFFFF, 0xFFFF, 0xFFFE, "z=false c=true o=false s=true"},
+		{opcode.ADD_OP, 0x7FFF, 1, 0x8000, "z=false c=false o=true s=true"},
+		{opcode.ADD_OP, 0x8000, 0x8000, 0, "z=true c=true o=true s=false"},
+		{opcode.SUB_OP, 5, 3, 2, "z=false c=false o=false s=false"},
+		{opcode.SUB_OP, 3, 3, 0, "z=true c=false o=false s=false"},
+		{opcode.SUB_OP, 3, 5, 0xFFFE, "z=false c=true o=false s=true"},
+		{opcode.SUB_OP, 0x8000, 1, 0x7FFF, "z=false c=false o=true s=false"},
+		{opcode.MUL_OP, 0x100, 0x100, 0, "z=true c=true o=true s=false"},
+		{opcode.MUL_OP, 0x1234, 0x10, 0x2340, "z=false c=true o=true s=false"},
+		{opcode.MUL_OP, 0xFFFF, 0xFFFF, 1, "z=false c=true o=false s=false"},
+		{opcode.MUL_OP, 0x10, 0x10, 0x100, "z=false c=false o=false s=false"},
+		{opcode.DIV_OP, 0xFFFF, 0x10, 0x0FFF, "z=false c=false o=false s=false"},
+		{opcode.AND_OP, 0xF0F0, 0x0F0F, 0, "z=true c=false o=false s=false"},
+		{opcode.OR_OP, 0xF000, 0x000F, 0xF00F, "z=false c=false o=false s=true"},
+		{opcode.XOR_OP, 0x1234, 0x1234, 0, "z=true c=false o=false s=false"},
+	}
+
+	c := NewCPU()
+	for _, test := range tests {
+		result := calculate(t, c, test.op, test.a, test.b)
+		if result != test.result || flags(c) != test.flags {
+			t.Errorf("%s %04X, %04X: expected %04X %s, got %04X %s", opcode.NewOpcode(byte(test.op)), test.a, test.b, test.result, test.flags, result, flags(c))
+		}
+	}
+}
+
+// TestIncDecFlags tests that inc and dec set the flags, but leave the
+// carry-flag alone.
+func TestIncDecFlags(t *testing.T) {
+
+	type TestCase struct {
+		op     int
+		val    int
+		result int
+		flags  string
+	}
+
+	tests := []TestCase{
+		{opcode.INC_OP, 1, 2, "z=false c=true o=false s=false"},
+		{opcode.INC_OP, 0xFFFF, 0, "z=true c=true o=false s=false"},
+		{opcode.INC_OP, 0x7FFF, 0x8000, "z=false c=true o=true s=true"},
+		{opcode.DEC_OP, 1, 0, "z=true c=true o=false s=false"},
+		{opcode.DEC_OP, 0, 0xFFFF, "z=false c=true o=false s=true"},
+		{opcode.DEC_OP, 0x8000, 0x7FFF, "z=false c=true o=true s=false"},
+	}
+
+	c := NewCPU()
+	for _, test := range tests {
+		c.LoadBytes([]byte{byte(test.op), 0x01, byte(opcode.EXIT)})
+		c.regs[1].SetInt(test.val)
+		c.flags.c = true
+		err := c.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		result, _ := c.regs[1].GetInt()
+		if result != test.result || flags(c) != test.flags {
+			t.Errorf("%s %04X: expected %04X %s, got %04X %s", opcode.NewOpcode(byte(test.op)), test.val, test.result, test.flags, result, flags(c))
+		}
+	}
+}
+
+// TestSaturating tests that the old, saturating, arithmetic is available.
+func TestSaturating(t *testing.T) {
+	c := NewCPU()
+	c.SetSaturating(true)
+
+	if calculate(t, c, opcode.ADD_OP, 0xFFFF, 0xFFFF) != 0xFFFF {
+		t.Errorf("expected add to saturate")
+	}
+	if calculate(t, c, opcode.MUL_OP, 0x100, 0x100) != 0xFFFF {
+		t.Errorf("expected mul to saturate")
+	}
+	if calculate(t, c, opcode.SUB_OP, 3, 5) != 0 || !c.flags.z {
+		t.Errorf("expected sub to saturate, setting z")
+	}
+
+	// Only the zero-flag is changed, and only by sub/inc/dec.
+	if flags(c) != "z=true c=false o=false s=false" {
+		t.Errorf("unexpected flags %s", flags(c))
+	}
+	c.flags.z = false
+	calculate(t, c, opcode.XOR_OP, 1, 1)
+	if flags(c) != "z=false c=false o=false s=false" {
+		t.Errorf("unexpected flags %s", flags(c))
+	}
+
+	// A later sub with a non-zero result leaves the zero-flag set.
+	c.LoadBytes([]byte{
+		byte(opcode.SUB_OP), 0x03, 0x01, 0x02,
+		byte(opcode.SUB_OP), 0x03, 0x02, 0x01,
+		byte(opcode.EXIT)})
+	c.regs[1].SetInt(3)
+	c.regs[2].SetInt(5)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	val, _ := c.regs[3].GetInt()
+	if val != 2 || !c.flags.z {
+		t.Errorf("expected sub to leave z set, got %d %s", val, flags(c))
+	}
+}
+
+// TestFlagJumps tests the jumps which test the carry, overflow, and sign
+// flags.
+func TestFlagJumps(t *testing.T) {
+
+	type TestCase struct {
+		op    int
+		flags Flags
+		jump  bool
+	}
+
+	tests := []TestCase{
+		{opcode.JUMP_C, Flags{c: true}, true},
+		{opcode.JUMP_C, Flags{z: true, o: true, s: true}, false},
+		{opcode.JUMP_NC, Flags{c: true}, false},
+		{opcode.JUMP_NC, Flags{z: true, o: true, s: true}, true},
+		{opcode.JUMP_O, Flags{o: true}, true},
+		{opcode.JUMP_O, Flags{z: true, c: true, s: true}, false},
+		{opcode.JUMP_NO, Flags{o: true}, false},
+		{opcode.JUMP_NO, Flags{z: true, c: true, s: true}, true},
+		{opcode.JUMP_N, Flags{s: true}, true},
+		{opcode.JUMP_N, Flags{z: true, c: true, o: true}, false},
+		{opcode.JUMP_NN, Flags{s: true}, false},
+		{opcode.JUMP_NN, Flags{z: true, c: true, o: true}, true},
+	}
+
+	c := NewCPU()
+	for _, test := range tests {
+		c.LoadBytes([]byte{
+			// 0000: jump to 0x0006
+			byte(test.op), 0x06, 0x00,
+			// 0003: inc #1
+			byte(opcode.INC_OP), 0x01,
+			// 0005: exit
+			byte(opcode.EXIT),
+			// 0006: exit
+			byte(opcode.EXIT),
+		})
+		c.flags = test.flags
+
+		err := c.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if (c.IP() == 6) != test.jump {
+			t.Errorf("%s with %+v: expected jump %t, stopped at %04X", opcode.NewOpcode(byte(test.op)), test.flags, test.jump, c.IP())
+		}
+	}
+}
+
+// TestCompare tests that comparisons record the ordering of their values,
+// and that the ordered jumps test it.
+func TestCompare(t *testing.T) {
+
+	type TestCase struct {
+		// program holds a comparison, which is followed by each
+		// of the jumps.
+		program []byte
+
+		// a and b are stored in #1 and #2 before it is executed.
+		a interface{}
+		b interface{}
+
+		// jumps holds the result of jmplt/jmple/jmpgt/jmpge.
+		jumps string
+	}
+
+	cmpReg := []byte{byte(opcode.CMP_REG), 0x01, 0x02}
+	cmpImm := []byte{byte(opcode.CMP_IMMEDIATE), 0x01, 0x05, 0x00}
+	cmpStr := []byte{byte(opcode.CMP_STRING), 0x01, 0x01, 0x00, 'm'}
+
+	tests := []TestCase{
+		{cmpReg, 1, 2, "lt le"},
+		{cmpReg, 2, 2, "le ge"},
+		{cmpReg, 3, 2, "gt ge"},
+		{cmpReg, 0xFFFF, 1, "gt ge"},
+		{cmpReg, "apple", "banana", "lt le"},
+		{cmpReg, "apple", "apple", "le ge"},
+		{cmpReg, "banana", "apple", "gt ge"},
+		{cmpReg, "a", "", "gt ge"},
+		{cmpImm, 4, 0, "lt le"},
+		{cmpImm, 5, 0, "le ge"},
+		{cmpImm, 6, 0, "gt ge"},
+		{cmpStr, "a", 0, "lt le"},
+		{cmpStr, "m", 0, "le ge"},
+		{cmpStr, "z", 0, "gt ge"},
+	}
+
+	jumps := []struct {
+		name string
+		op   int
+	}{
+		{"lt", opcode.JUMP_LT},
+		{"le", opcode.JUMP_LE},
+		{"gt", opcode.JUMP_GT},
+		{"ge", opcode.JUMP_GE},
+	}
+
+	c := NewCPU()
+	for _, test := range tests {
+		var taken []string
+		for _, jump := range jumps {
+			program := append([]byte{}, test.program...)
+			target := byte(len(program) + 6)
+			program = append(program,
+				// jump to the second exit
+				byte(jump.op), target, 0x00,
+				byte(opcode.INC_OP), 0x03,
+				byte(opcode.EXIT),
+				byte(opcode.EXIT))
+
+			c.LoadBytes(program)
+			for i, v := range []interface{}{test.a, test.b} {
+				if str, ok := v.(string); ok {
+					c.regs[i+1].SetString(str)
+				} else {
+					c.regs[i+1].SetInt(v.(int))
+				}
+			}
+
+			err := c.Run()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.IP() == int(target) {
+				taken = append(taken, jump.name)
+			}
+		}
+
+		if strings.Join(taken, " ") != test.jumps {
+			t.Errorf("% X with %v, %v: expected %s, got %v", test.program, test.a, test.b, test.jumps, taken)
+		}
+	}
+}
+
+// TestCompareClearsZ tests that a failed comparison clears the Z-flag,
+// as does a subtraction with a non-zero result, unless arithmetic is
+// saturating.
+func TestCompareClearsZ(t *testing.T) {
+	programs := [][]byte{
+		{byte(opcode.CMP_IMMEDIATE), 0x01, 0x05, 0x00, byte(opcode.EXIT)},
+		{byte(opcode.CMP_STRING), 0x01, 0x01, 0x00, 'x', byte(opcode.EXIT)},
+		{byte(opcode.SUB_OP), 0x01, 0x01, 0x02, byte(opcode.EXIT)},
+	}
+
+	for _, saturating := range []bool{false, true} {
+		c := NewCPU()
+		c.SetSaturating(saturating)
+		for i, program := range programs {
+			if saturating && program[0] == byte(opcode.SUB_OP) {
+				continue
+			}
+			c.LoadBytes(program)
+			c.flags.z = true
+			if i == 1 {
+				c.regs[1].SetString("y")
+			} else {
+				c.regs[1].SetInt(7)
+				c.regs[2].SetInt(2)
+			}
+
+			err := c.Run()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.flags.z {
+				t.Errorf("% X: expected the Z-flag to be cleared", program)
+			}
+		}
+	}
+}
+
+// TestShifts tests shifting left and right.
+func TestShifts(t *testing.T) {
+
+	type TestCase struct {
+		op     int
+		signed bool
+		a      int
+		b      int
+		result int
+		flags  string
+	}
+
+	tests := []TestCase{
+		{opcode.SHL_OP, false, 0x0001, 4, 0x0010, "z=false c=false o=false s=false"},
+		{opcode.SHL_OP, false, 0x8001, 1, 0x0002, "z=false c=true o=false s=false"},
+		{opcode.SHL_OP, false, 0x1234, 16, 0x0000, "z=true c=false o=false s=false"},
+		{opcode.SHL_OP, false, 0x1234, 17, 0x0000, "z=true c=false o=false s=false"},
+		{opcode.SHR_OP, false, 0x8001, 1, 0x4000, "z=false c=true o=false s=false"},
+		{opcode.SHR_OP, false, 0x8000, 15, 0x0001, "z=false c=false o=false s=false"},
+		{opcode.SHR_OP, true, 0x8000, 15, 0xFFFF, "z=false c=false o=false s=true"},
+		{opcode.SHR_OP, true, 0xFFF0, 2, 0xFFFC, "z=false c=false o=false s=true"},
+		{opcode.SHR_OP, true, 0x7FF0, 4, 0x07FF, "z=false c=false o=false s=false"},
+		{opcode.SHR_OP, true, 0xFFFF, 20, 0xFFFF, "z=false c=true o=false s=true"},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.SetSigned(test.signed)
+		result := calculate(t, c, test.op, test.a, test.b)
+		if result != test.result || flags(c) != test.flags {
+			t.Errorf("%s %04X, %d: expected %04X %s, got %04X %s", opcode.NewOpcode(byte(test.op)), test.a, test.b, test.result, test.flags, result, flags(c))
+		}
+	}
+}
+
+// TestSigned tests the instructions which behave differently in signed
+// mode.
+func TestSigned(t *testing.T) {
+	c := NewCPU()
+	c.SetSigned(true)
+
+	// -6 / 4 = -1, as we round towards zero
+	if calculate(t, c, opcode.DIV_OP, 0xFFFA, 4) != 0xFFFF || !c.flags.s {
+		t.Errorf("signed division failed")
+	}
+
+	// -32768 / -1 overflows
+	if calculate(t, c, opcode.DIV_OP, 0x8000, 0xFFFF) != 0x8000 || !c.flags.o {
+		t.Errorf("signed division failed to overflow")
+	}
+
+	// Printing, and converting to and from strings
+	for _, test := range []struct {
+		val int
+		out string
+	}{
+		{0xFFFB, "-05-5"},
+		{0x8000, "-8000-32768"},
+		{0x7FFF, "7FFF32767"},
+		{0x0010, "1016"},
+	} {
+		var out bytes.Buffer
+		c.STDOUT = bufio.NewWriter(&out)
+		c.LoadBytes([]byte{
+			// 0000: print_int #1
+			byte(opcode.INT_PRINT), 0x01,
+			// 0002: int2string #1
+			byte(opcode.INT_TOSTRING), 0x01,
+			// 0004: print_str #1
+			byte(opcode.STRING_PRINT), 0x01,
+			// 0006: string2int #1
+			byte(opcode.STRING_TOINT), 0x01,
+			// 0008: exit
+			byte(opcode.EXIT),
+		})
+		c.regs[1].SetInt(test.val)
+
+		err := c.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		val, _ := c.regs[1].GetInt()
+		if out.String() != test.out || val != test.val {
+			t.Errorf("%04X: expected %q, got %q and %04X", test.val, test.out, out.String(), val)
+		}
+	}
+
+	// Converting a string which is out of range clamps it.
+	for str, expected := range map[string]int{"-40000": 0x8000, "40000": 0x7FFF} {
+		c.LoadBytes([]byte{byte(opcode.STRING_TOINT), 0x01, byte(opcode.EXIT)})
+		c.regs[1].SetString(str)
+		err := c.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if val, _ := c.regs[1].GetInt(); val != expected {
+			t.Errorf("%s: expected %04X, got %04X", str, expected, val)
+		}
+	}
+
+	// Random numbers may be generated within a negative range.
+	for i := 0; i < 10; i++ {
+		for _, n := range roll(t, c, 0xFFFB, 0x0005) {
+			if n > 0x0005 && n < 0xFFFB {
+				t.Fatalf("%04X is outside the range -5-5", n)
+			}
+		}
+	}
+}
+
+// TestSignedCompare tests that the ordered jumps follow signed
+// comparisons in signed mode.
+func TestSignedCompare(t *testing.T) {
+
+	// Compare #1 with #2, setting #3 if #1 is less than #2.
+	program := []byte{
+		// 0000: cmp #1, #2
+		byte(opcode.CMP_REG), 0x01, 0x02,
+		// 0003: jmpge 0x0008
+		byte(opcode.JUMP_GE), 0x08, 0x00,
+		// 0006: inc #3
+		byte(opcode.INC_OP), 0x03,
+		// 0008: exit
+		byte(opcode.EXIT),
+	}
+
+	tests := []struct {
+		a        int
+		b        int
+		unsigned bool
+		signed   bool
+	}{
+		{1, 2, true, true},
+		{2, 1, false, false},
+		{0xFFFF, 1, false, true},
+		{1, 0xFFFF, true, false},
+		{0x8000, 0x7FFF, false, true},
+		{0x7FFF, 0x8000, true, false},
+		{0xFFFE, 0xFFFF, true, true},
+	}
+
+	for _, test := range tests {
+		for _, signed := range []bool{false, true} {
+			c := NewCPU()
+			c.SetSigned(signed)
+			c.LoadBytes(program)
+			c.regs[1].SetInt(test.a)
+			c.regs[2].SetInt(test.b)
+
+			err := c.Run()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expected := test.unsigned
+			if signed {
+				expected = test.signed
+			}
+			less, _ := c.regs[3].GetInt()
+			if (less == 1) != expected {
+				t.Errorf("%04X < %04X, signed %t: expected %t", test.a, test.b, signed, expected)
+			}
+		}
+	}
+}
+
+// TestWordSize tests arithmetic with 32-bit and 64-bit words.
+func TestWordSize(t *testing.T) {
+
+	type TestCase struct {
+		size   int
+		signed bool
+		op     int
+		a      uint64
+		b      uint64
+		result uint64
+		flags  string
+	}
+
+	tests := []TestCase{
+		{32, false, opcode.ADD_OP, 0xFFFF, 1, 0x10000, "z=false c=false o=false s=false"},
+		{32, false, opcode.ADD_OP, 0xFFFFFFFF, 1, 0, "z=true c=true o=false s=false"},
+		{32, false, opcode.ADD_OP, 0x7FFFFFFF, 1, 0x80000000, "z=false c=false o=true s=true"},
+		{32, false, opcode.SUB_OP, 0, 1, 0xFFFFFFFF, "z=false c=true o=false s=true"},
+		{32, false, opcode.MUL_OP, 0x10000, 0x10000, 0, "z=true c=true o=true s=false"},
+		{32, false, opcode.SHL_OP, 0x80000001, 1, 2, "z=false c=true o=false s=false"},
+		{32, true, opcode.DIV_OP, 0x80000000, 0xFFFFFFFF, 0x80000000, "z=false c=false o=true s=true"},
+		{32, true, opcode.SHR_OP, 0x80000000, 31, 0xFFFFFFFF, "z=false c=false o=false s=true"},
+		{64, false, opcode.ADD_OP, 0xFFFFFFFF, 1, 0x100000000, "z=false c=false o=false s=false"},
+		{64, false, opcode.ADD_OP, 0xFFFFFFFFFFFFFFFF, 2, 1, "z=false c=true o=false s=false"},
+		{64, false, opcode.ADD_OP, 0x7FFFFFFFFFFFFFFF, 1, 0x8000000000000000, "z=false c=false o=true s=true"},
+		{64, false, opcode.SUB_OP, 1, 2, 0xFFFFFFFFFFFFFFFF, "z=false c=true o=false s=true"},
+		{64, false, opcode.MUL_OP, 0x100000000, 0x100000000, 0, "z=true c=true o=true s=false"},
+		{64, false, opcode.MUL_OP, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF, 1, "z=false c=true o=false s=false"},
+		{64, false, opcode.MUL_OP, 0x8000000000000000, 0xFFFFFFFFFFFFFFFF, 0x8000000000000000, "z=false c=true o=true s=true"},
+		{64, false, opcode.DIV_OP, 0xFFFFFFFFFFFFFFFF, 2, 0x7FFFFFFFFFFFFFFF, "z=false c=false o=false s=false"},
+		{64, true, opcode.DIV_OP, 0xFFFFFFFFFFFFFFFA, 4, 0xFFFFFFFFFFFFFFFF, "z=false c=false o=false s=true"},
+		{64, true, opcode.DIV_OP, 0x8000000000000000, 0xFFFFFFFFFFFFFFFF, 0x8000000000000000, "z=false c=false o=true s=true"},
+		{64, false, opcode.SHL_OP, 1, 63, 0x8000000000000000, "z=false c=false o=false s=true"},
+		{64, false, opcode.SHL_OP, 3, 64, 0, "z=true c=true o=false s=false"},
+		{64, false, opcode.SHR_OP, 0x8000000000000000, 63, 1, "z=false c=false o=false s=false"},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.SetSigned(test.signed)
+		err := c.SetWordSize(test.size)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		c.LoadBytes([]byte{byte(test.op), 0x03, 0x01, 0x02, byte(opcode.EXIT)})
+		c.regs[1].SetWord(test.a)
+		c.regs[2].SetWord(test.b)
+		err = c.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result, _ := c.regs[3].GetWord()
+		if result != test.result || flags(c) != test.flags {
+			t.Errorf("%d-bit %s %X, %X: expected %X %s, got %X %s", test.size, opcode.NewOpcode(byte(test.op)), test.a, test.b, test.result, test.flags, result, flags(c))
+		}
+	}
+
+	// Incrementing the largest signed value overflows.
+	c := NewCPU()
+	c.SetWordSize(32)
+	c.LoadBytes([]byte{byte(opcode.INC_OP), 0x01, byte(opcode.EXIT)})
+	c.regs[1].SetWord(0x7FFFFFFF)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if val, _ := c.regs[1].GetWord(); val != 0x80000000 || flags(c) != "z=false c=false o=true s=true" {
+		t.Errorf("unexpected result %X %s", val, flags(c))
+	}
+
+	// Saturating arithmetic clamps to the size of the word.
+	c = NewCPU()
+	c.SetWordSize(32)
+	c.SetSaturating(true)
+	if calculate(t, c, opcode.ADD_OP, 0xFFFF, 0xFFFF) != 0x1FFFE {
+		t.Errorf("saturating addition clamped to 16 bits")
+	}
+	c.LoadBytes([]byte{byte(opcode.MUL_OP), 0x03, 0x01, 0x02, byte(opcode.EXIT)})
+	c.regs[1].SetWord(0xFFFFFFFF)
+	c.regs[2].SetWord(2)
+	err = c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if val, _ := c.regs[3].GetWord(); val != 0xFFFFFFFF {
+		t.Errorf("saturating multiplication gave %X", val)
+	}
+}
+
+// TestSetWordSize tests that only valid sizes are accepted, and that
+// registers are truncated to fit.
+func TestSetWordSize(t *testing.T) {
+	c := NewCPU()
+	if c.WordSize() != 16 {
+		t.Fatalf("unexpected default word size %d", c.WordSize())
+	}
+
+	for _, size := range []int{0, 8, 24, 128} {
+		if c.SetWordSize(size) == nil {
+			t.Errorf("expected an error setting the word size to %d", size)
+		}
+	}
+
+	err := c.SetWordSize(64)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.regs[1].SetWord(0x123456789A)
+	c.regs[2].SetString("steve")
+
+	err = c.SetWordSize(32)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if val, _ := c.regs[1].GetWord(); val != 0x3456789A {
+		t.Errorf("register was not truncated, got %X", val)
+	}
+	if str, _ := c.regs[2].GetString(); str != "steve" {
+		t.Errorf("string register was changed, got %q", str)
+	}
+}
+
+// TestWideImmediates tests storing, and comparing, four- and eight-byte
+// numbers.
+func TestWideImmediates(t *testing.T) {
+
+	program := []byte{
+		// 0000: store #1, 0x123456789A
+		byte(opcode.INT_STORE64), 0x01, 0x9A, 0x78, 0x56, 0x34, 0x12, 0x00, 0x00, 0x00,
+		// 000A: store #2, -2
+		byte(opcode.INT_STORE32), 0x02, 0xFE, 0xFF, 0xFF, 0xFF,
+		// 0010: cmp #1, 0x123456789A
+		byte(opcode.CMP_IMMEDIATE64), 0x01, 0x9A, 0x78, 0x56, 0x34, 0x12, 0x00, 0x00, 0x00,
+		// 001A: print_int #1
+		byte(opcode.INT_PRINT), 0x01,
+		// 001C: int2string #2
+		byte(opcode.INT_TOSTRING), 0x02,
+		// 001E: print_str #2
+		byte(opcode.STRING_PRINT), 0x02,
+		// 0020: exit
+		byte(opcode.EXIT),
+	}
+
+	tests := []struct {
+		size   int
+		signed bool
+		out    string
+		err    string
+	}{
+		{16, false, "", "value 78187493530 does not fit in a 16-bit word"},
+		{32, false, "", "value 78187493530 does not fit in a 32-bit word"},
+		{64, false, "123456789A18446744073709551614", ""},
+		{64, true, "123456789A-2", ""},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		c := NewCPU()
+		c.STDOUT = bufio.NewWriter(&out)
+		c.SetSigned(test.signed)
+		c.SetWordSize(test.size)
+		c.LoadBytes(program)
+
+		err := c.Run()
+		c.STDOUT.Flush()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%d-bit: expected error %q, got %v", test.size, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !c.flags.z {
+			t.Errorf("%d-bit: comparison failed", test.size)
+		}
+		if out.String() != test.out {
+			t.Errorf("%d-bit: expected %q, got %q", test.size, test.out, out.String())
+		}
+	}
+
+	// A negative four-byte number fits in a 16-bit word.
+	c := NewCPU()
+	c.LoadBytes(append(program[0x0A:0x10:0x10], byte(opcode.EXIT)))
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if val, _ := c.regs[2].GetInt(); val != 0xFFFE {
+		t.Errorf("expected FFFE, got %04X", val)
+	}
+}
+
+// TestReturnAddress tests that returning to an address which has been
+// pushed from a register, and is outside RAM, fails rather than panics.
+func TestReturnAddress(t *testing.T) {
+	c := NewCPU()
+	c.SetWordSize(64)
+	c.LoadBytes([]byte{
+		// 0000: store #1, 0x8000000000000000
+		byte(opcode.INT_STORE64), 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80,
+		// 000A: push #1
+		byte(opcode.STACK_PUSH), 0x01,
+		// 000C: ret
+		byte(opcode.STACK_RET),
+	})
+
+	err := c.Run()
+	if err == nil || err.Error() != "return address out of range 9223372036854775808" {
+		t.Errorf("expected an out of range error, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package cpu
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// benchmark runs the given program b.N times.
+func benchmark(b *testing.B, program []byte) {
+	c := NewCPU()
+	c.STDOUT = bufio.NewWriter(ioutil.Discard)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LoadBytes(program)
+		err := c.Run()
+		if err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+// BenchmarkLoop counts down from 10,000, as examples/loop.in does.
+func BenchmarkLoop(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: store #2, 1
+		byte(opcode.INT_STORE), 0x02, 0x01, 0x00,
+		// 0008: sub #1, #1, #2
+		byte(opcode.SUB_OP), 0x01, 0x01, 0x02,
+		// 000C: jmpnz 0x0008
+		byte(opcode.JUMP_NZ), 0x08, 0x00,
+		// 000F: exit
+		byte(opcode.EXIT),
+	})
+}
+
+// BenchmarkArithmetic performs a mixture of arithmetic, 10,000 times.
+func BenchmarkArithmetic(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: store #2, 3
+		byte(opcode.INT_STORE), 0x02, 0x03, 0x00,
+		// 0008: add #3, #2, #2
+		byte(opcode.ADD_OP), 0x03, 0x02, 0x02,
+		// 000C: mul #3, #3, #2
+		byte(opcode.MUL_OP), 0x03, 0x03, 0x02,
+		// 0010: xor #4, #3, #2
+		byte(opcode.XOR_OP), 0x04, 0x03, 0x02,
+		// 0014: cmp #4, #3
+		byte(opcode.CMP_REG), 0x04, 0x03,
+		// 0017: dec #1
+		byte(opcode.DEC_OP), 0x01,
+		// 0019: jmpnz 0x0008
+		byte(opcode.JUMP_NZ), 0x08, 0x00,
+		// 001C: exit
+		byte(opcode.EXIT),
+	})
+}
+
+// BenchmarkCall calls a subroutine 10,000 times.
+func BenchmarkCall(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: call 0x000D
+		byte(opcode.STACK_CALL), 0x0D, 0x00,
+		// 0007: dec #1
+		byte(opcode.DEC_OP), 0x01,
+		// 0009: jmpnz 0x0004
+		byte(opcode.JUMP_NZ), 0x04, 0x00,
+		// 000C: exit
+		byte(opcode.EXIT),
+		// 000D: push #1
+		byte(opcode.STACK_PUSH), 0x01,
+		// 000F: pop #2
+		byte(opcode.STACK_POP), 0x02,
+		// 0011: ret
+		byte(opcode.STACK_RET),
+	})
+}
+
+// BenchmarkPrint prints a string, and an integer, 1,000 times.
+func BenchmarkPrint(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 1000
+		byte(opcode.INT_STORE), 0x01, 0xE8, 0x03,
+		// 0004: store #2, "Hello\n"
+		byte(opcode.STRING_STORE), 0x02, 0x06, 0x00, 'H', 'e', 'l', 'l', 'o', '\n',
+		// 000E: print_int #1
+		byte(opcode.INT_PRINT), 0x01,
+		// 0010: print_str #2
+		byte(opcode.STRING_PRINT), 0x02,
+		// 0012: dec #1
+		byte(opcode.DEC_OP), 0x01,
+		// 0014: jmpnz 0x0004
+		byte(opcode.JUMP_NZ), 0x04, 0x00,
+		// 0017: exit
+		byte(opcode.EXIT),
+	})
+}
+
+// BenchmarkAdd adds a pair of registers 10,000 times.
+func BenchmarkAdd(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: store #2, 1
+		byte(opcode.INT_STORE), 0x02, 0x01, 0x00,
+		// 0008: add #3, #3, #2
+		byte(opcode.ADD_OP), 0x03, 0x03, 0x02,
+		// 000C: dec #1
+		byte(opcode.DEC_OP), 0x01,
+		// 000E: jmpnz 0x0008
+		byte(opcode.JUMP_NZ), 0x08, 0x00,
+		// 0011: exit
+		byte(opcode.EXIT),
+	})
+}
+
+// BenchmarkInc increments a register 10,000 times.
+func BenchmarkInc(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: inc #2
+		byte(opcode.INC_OP), 0x02,
+		// 0006: cmp #2, #1
+		byte(opcode.CMP_REG), 0x02, 0x01,
+		// 0009: jmpnz 0x0004
+		byte(opcode.JUMP_NZ), 0x04, 0x00,
+		// 000C: exit
+		byte(opcode.EXIT),
+	})
+}
+
+// BenchmarkCmp compares a register against a constant 10,000 times.
+func BenchmarkCmp(b *testing.B) {
+	benchmark(b, []byte{
+		// 0000: store #1, 10000
+		byte(opcode.INT_STORE), 0x01, 0x10, 0x27,
+		// 0004: dec #1
+		byte(opcode.DEC_OP), 0x01,
+		// 0006: cmp #1, 0
+		byte(opcode.CMP_IMMEDIATE), 0x01, 0x00, 0x00,
+		// 000A: jmpnz 0x0004
+		byte(opcode.JUMP_NZ), 0x04, 0x00,
+		// 000E: exit
+		byte(opcode.EXIT),
+	})
+}
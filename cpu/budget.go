@@ -0,0 +1,53 @@
+// This file contains the instruction budget, which allows the number of
+// instructions a program executes to be capped.
+//
+// Unlike a timeout, set via SetContext, the budget is deterministic: a
+// program given the same input will always stop at the same point.
+//
+// Each instruction costs a single step by default, but the cost of an
+// individual opcode may be changed - for example to make traps, or
+// `memcpy`, more expensive than arithmetic.
+
+package cpu
+
+import "errors"
+
+// ErrBudgetExhausted is returned when a program attempts to execute an
+// instruction which would exceed its budget.
+var ErrBudgetExhausted = errors.New("instruction budget exhausted")
+
+// SetMaxSteps sets the number of steps the program may consume, zero
+// meaning there is no limit.
+//
+// Once the budget is exhausted execution stops, with ErrBudgetExhausted,
+// before the instruction which would exceed it is executed.
+func (c *CPU) SetMaxSteps(max int) {
+	c.maxSteps = max
+}
+
+// SetCost sets the number of steps consumed by each execution of the
+// given opcode, which defaults to one.
+func (c *CPU) SetCost(op int, cost int) {
+	if op < 0 || op >= len(c.costs) || cost < 0 {
+		return
+	}
+	c.costs[op] = cost
+}
+
+// Steps returns the number of steps the program has consumed.
+//
+// Unless the cost of any opcode has been changed this is the number of
+// instructions which have been executed.
+func (c *CPU) Steps() int {
+	return c.steps
+}
+
+// consume charges the cost of the given opcode against the budget.
+func (c *CPU) consume(op byte) error {
+	cost := c.costs[op]
+	if c.maxSteps > 0 && c.steps+cost > c.maxSteps {
+		return ErrBudgetExhausted
+	}
+	c.steps += cost
+	return nil
+}
@@ -0,0 +1,85 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// TestBudget tests that an infinite loop is stopped by the budget.
+func TestBudget(t *testing.T) {
+	c := NewCPU()
+	c.SetMaxSteps(100)
+	c.LoadBytes([]byte{
+		// 0000: inc #1
+		byte(opcode.INC_OP), 0x01,
+		// 0002: jmp 0x0000
+		byte(opcode.JUMP_TO), 0x00, 0x00,
+	})
+
+	err := c.Run()
+	fault, ok := err.(*Fault)
+	if !ok || fault.Err != ErrBudgetExhausted {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+	if c.Steps() != 100 {
+		t.Fatalf("expected 100 steps, got %d", c.Steps())
+	}
+
+	// The instruction which would exceed the budget isn't executed.
+	if fault.IP != 0 {
+		t.Fatalf("expected to stop at 0000, got %04X", fault.IP)
+	}
+	val, _ := c.Register(1).GetInt()
+	if val != 50 {
+		t.Fatalf("expected 50 increments, got %d", val)
+	}
+}
+
+// TestCosts tests changing the cost of an opcode.
+func TestCosts(t *testing.T) {
+	program := []byte{
+		byte(opcode.NOP_OP),
+		byte(opcode.NOP_OP),
+		byte(opcode.INC_OP), 0x01,
+		byte(opcode.EXIT),
+	}
+
+	c := NewCPU()
+	c.LoadBytes(program)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.Steps() != 4 {
+		t.Fatalf("expected 4 steps, got %d", c.Steps())
+	}
+
+	// NOPs are free, and increments expensive.
+	c.SetCost(opcode.NOP_OP, 0)
+	c.SetCost(opcode.INC_OP, 10)
+	c.LoadBytes(program)
+	err = c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.Steps() != 11 {
+		t.Fatalf("expected 11 steps, got %d", c.Steps())
+	}
+
+	// A budget of exactly the cost of the program is sufficient.
+	c.SetMaxSteps(11)
+	c.LoadBytes(program)
+	err = c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// But one fewer stops before the final `exit`.
+	c.SetMaxSteps(10)
+	c.LoadBytes(program)
+	err = c.Run()
+	if err == nil || err.(*Fault).Err != ErrBudgetExhausted || err.(*Fault).IP != 4 {
+		t.Fatalf("expected the budget to be exhausted at 0004, got %v", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -18,19 +19,32 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/skx/go.vm/bytecode"
 	"github.com/skx/go.vm/opcode"
 )
 
-// Flags holds the CPU flags - of which we only have one.
+// RegisterCount is the number of registers our CPU has.
+const RegisterCount = 15
+
+// Flags holds the CPU flags.
 type Flags struct {
 	// Zero-flag
 	z bool
+
+	// Carry-flag
+	c bool
+
+	// Overflow-flag
+	o bool
+
+	// Sign-flag
+	s bool
 }
 
 // CPU is our virtual machine state.
 type CPU struct {
 	// Registers
-	regs [15]*Register
+	regs [RegisterCount]Register
 
 	// Flags
 	flags Flags
@@ -41,6 +55,65 @@ type CPU struct {
 	// Instruction-pointer
 	ip int
 
+	// Address of the instruction currently being executed, which is
+	// used to report the location of faults.
+	current int
+
+	// halted is set once the program has executed `exit`.
+	halted bool
+
+	// observers are notified as the program executes.
+	observers []Observer
+
+	// limits holds the resource limits of the program, and written and
+	// read the number of bytes written to STDOUT and read from STDIN.
+	limits  Limits
+	written int
+	read    int
+
+	// rng is the source of random numbers, and seeded its source if
+	// that was created from a seed.
+	rng    *rand.Rand
+	seeded *seededSource
+
+	// recording receives each input to the program, if non-nil.
+	recording *json.Encoder
+
+	// replaying is set when the inputs are being replayed from replay,
+	// of which replayed have been consumed.
+	replaying bool
+	replay    []Event
+	replayed  int
+
+	// steps is the number of steps the program has consumed, and
+	// maxSteps the number it may consume, if non-zero.
+	steps    int
+	maxSteps int
+
+	// costs holds the number of steps consumed by each opcode.
+	costs [256]int
+
+	// saturating is set if arithmetic results are clamped, rather than
+	// wrapping around.
+	saturating bool
+
+	// signed is set if integers are treated as signed values.
+	signed bool
+
+	// width is the number of bits in our integers: 16, 32, or 64.
+	width uint
+
+	// polls counts the instructions executed, so that our context may
+	// be tested periodically.
+	polls int
+
+	// cache holds the decoded instruction at each address, and code
+	// records which addresses are part of a decoded instruction.
+	// cached is set if either is non-empty.
+	cache  [0xFFFF]*instruction
+	code   [0xFFFF]bool
+	cached bool
+
 	// stack
 	stack *Stack
 
@@ -60,9 +133,17 @@ type CPU struct {
 
 // NewCPU returns a new CPU object.
 func NewCPU() *CPU {
-	x := &CPU{context: context.Background()}
+	x := &CPU{context: context.Background(), width: 16}
 	x.Reset()
 
+	// seed our random numbers from the time
+	x.SetSeed(time.Now().UnixNano())
+
+	// every instruction costs a single step by default
+	for i := range x.costs {
+		x.costs[i] = 1
+	}
+
 	// allow reading from STDIN
 	x.STDIN = bufio.NewReader(os.Stdin)
 
@@ -81,22 +162,34 @@ func (c *CPU) SetContext(ctx context.Context) {
 }
 
 // Reset sets the CPU into a known-good state, by setting the IP to zero,
-// and emptying all registers (i.e. setting them to zero too).
+// clearing the flags, and emptying all registers (i.e. setting them to
+// zero too).
 func (c *CPU) Reset() {
 
+	// Discard any decoded instructions, as RAM is about to change.
+	c.flush()
+
 	// Reset registers
-	for i := 0; i < len(c.regs); i++ {
-		c.regs[i] = NewRegister()
-	}
+	c.regs = [RegisterCount]Register{}
 
 	// Reset stack
 	c.stack = NewStack()
 
+	// Reset flags
+	c.flags = Flags{}
+
 	// Reset instruction pointer to zero.
 	c.ip = 0
+	c.halted = false
+	c.steps = 0
+	c.written = 0
+	c.read = 0
 }
 
 // LoadFile loads the program from the named file into RAM.
+//
+// The file may contain a program within our bytecode container, which
+// is validated, or a bare program which is loaded at address zero.
 // NOTE: The CPU-state is reset prior to the load.
 func (c *CPU) LoadFile(path string) error {
 
@@ -106,16 +199,31 @@ func (c *CPU) LoadFile(path string) error {
 		return fmt.Errorf("failed to read file: %s - %s", path, err.Error())
 	}
 
-	if len(b) >= 0xFFFF {
-		return fmt.Errorf("program too large for RAM %d", len(b))
+	prog, err := bytecode.Load(b)
+	if err != nil {
+		return fmt.Errorf("failed to load file: %s - %s", path, err.Error())
 	}
 
-	// Copy contents of file to our memory region.
+	// Copy the program to our memory region.
 	// NOTE: This calls `Reset` too :)
-	c.LoadBytes(b)
+	c.LoadProgram(prog)
 	return nil
 }
 
+// LoadProgram populates the sections of the given program into RAM, and
+// prepares to execute from its entry-point.
+// NOTE: The CPU-state is reset prior to the load.
+func (c *CPU) LoadProgram(prog *bytecode.Program) {
+
+	// Ensure we reset our state.
+	c.Reset()
+
+	for _, s := range prog.Sections {
+		copy(c.mem[s.Address:], s.Data)
+	}
+	c.ip = prog.Entry
+}
+
 // LoadBytes populates the given program into RAM.
 // NOTE: The CPU-state is reset prior to the load.
 func (c *CPU) LoadBytes(data []byte) {
@@ -136,1005 +244,845 @@ func (c *CPU) LoadBytes(data []byte) {
 	}
 }
 
-// Read a string from the IP position
-// Strings are prefixed by their lengths (two-bytes).
-func (c *CPU) readString() (string, error) {
+// Fault is the error returned when a program fails to execute, it records
+// the address of the instruction which was being executed at the time.
+type Fault struct {
+	// IP is the address of the faulting instruction.
+	IP int
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (f *Fault) Error() string {
+	return f.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// Run launches our intepreter.
+// It does not terminate until an `EXIT` instruction is hit.
+//
+// Any error returned will be a *Fault, recording the address of the
+// instruction which failed.
+func (c *CPU) Run() error {
+	err := c.run()
+	if err != nil {
+		return &Fault{IP: c.current, Err: err}
+	}
+	return nil
+}
+
+// run contains our main-loop.
+func (c *CPU) run() error {
+	for !c.halted {
+		err := c.step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step executes the single instruction at our instruction-pointer.
+func (c *CPU) step() error {
 
-	// Read the length of the string we expect
-	len := c.read2Val()
+	c.current = c.ip
 
-	// Can't read beyond RAM, but we will allow wrap-around.
-	if len >= 0xffff {
-		return "", fmt.Errorf("string too large")
+	if c.ip < 0 || c.ip >= len(c.mem) {
+		return fmt.Errorf("reading beyond RAM")
 	}
 
-	addr := c.ip
+	op := c.mem[c.ip]
+	if debugging {
+		debugPrintf("%04X %02X [%s]\n", c.ip, op, opcode.NewOpcode(op).String())
+	}
 
-	// Now build up the body of the string
-	s := ""
-	for i := 0; i < len; i++ {
+	//
+	// We've been given a context, which we'll test periodically,
+	// rather than upon every instruction, as doing so is relatively
+	// expensive.
+	//
+	c.polls++
+	if c.polls%pollInterval == 0 {
+		select {
+		case <-c.context.Done():
+			return fmt.Errorf("timeout during execution")
+		default:
+			// nop
+		}
+	}
 
-		tmp := addr + i
+	err := c.consume(op)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range c.observers {
+		o.BeforeInstruction(c, c.ip, op)
+	}
 
-		// wrap around
-		if tmp == 0xFFFF {
-			tmp = 0
+	// Find the decoded instruction, decoding it if we've not seen it
+	// before.
+	in := c.cache[c.ip]
+	if in == nil {
+		in, err = c.decode(c.ip)
+		if err != nil {
+			return err
 		}
-		s += string(c.mem[tmp])
 	}
 
-	// Jump the IP over the length of the string.
-	c.ip += (len)
-	return s, nil
+	// Move to the next instruction, unless the handler jumps elsewhere.
+	c.ip = in.next
+	err = in.handler(c, in)
+	if err != nil {
+		return err
+	}
+
+	// Ensure our instruction-pointer wraps around.
+	if c.ip > 0xFFFF {
+		c.ip = 0
+	}
+
+	return nil
+}
+
+//
+// The handlers for each instruction follow.
+//
+// Each is given the decoded instruction, whose register operands have
+// already been bounds-checked, and is invoked with the instruction-pointer
+// already pointing to the following instruction.
+//
+
+func (c *CPU) exitOp(in *instruction) error {
+	c.ip = c.current
+	c.halted = true
+	return nil
 }
 
-// Read a two-byte number from the current IP.
-// i.e This reads two bytes and returns a 16-bit value to the caller,
-// skipping over both bytes in the IP.
-func (c *CPU) read2Val() int {
-	l := int(c.mem[c.ip])
-	c.ip++
-	h := int(c.mem[c.ip])
-	c.ip++
+func (c *CPU) intStoreOp(in *instruction) error {
+	c.setInt(in.regs[0], int(in.value))
+	return nil
+}
 
-	val := l + h*256
-	return (val)
+func (c *CPU) intStoreWideOp(in *instruction) error {
+	val, err := c.immediate(in.value)
+	if err != nil {
+		return err
+	}
+	c.setWord(in.regs[0], val)
+	return nil
 }
 
-// Run launches our intepreter.
-// It does not terminate until an `EXIT` instruction is hit.
-func (c *CPU) Run() error {
-	run := true
-	for run {
+func (c *CPU) intPrintOp(in *instruction) error {
+	val, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+
+	// negative numbers are shown with a leading minus
+	sign := ""
+	if c.signed && c.integer(val) < 0 {
+		sign = "-"
+		val = uint64(-c.integer(val))
+	}
+	if val < 256 {
+		return c.write(fmt.Sprintf("%s%02X", sign, val))
+	}
+	return c.write(fmt.Sprintf("%s%04X", sign, val))
+}
 
-		if c.ip >= 0xffff {
-			return fmt.Errorf("reading beyond RAM")
+func (c *CPU) intToStringOp(in *instruction) error {
+	i, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+
+	// change from int to string
+	return c.setString(in.regs[0], c.decimal(i))
+}
+
+func (c *CPU) intRandomOp(in *instruction) error {
+	val, err := c.random(0xffff)
+	if err != nil {
+		return err
+	}
+	c.setInt(in.regs[0], val)
+	return nil
+}
+
+func (c *CPU) intRandomRangeOp(in *instruction) error {
+	// get the range, which is inclusive
+	min, max, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.signed && c.integer(min) > c.integer(max) || !c.signed && min > max {
+		return fmt.Errorf("invalid random range %s-%s", c.decimal(min), c.decimal(max))
+	}
+
+	// The span can't be represented if it's larger than an int.
+	span := (max - min) & c.mask()
+	if span >= uint64(maxInt) {
+		return fmt.Errorf("random range %s-%s is too large", c.decimal(min), c.decimal(max))
+	}
+
+	val, err := c.random(int(span + 1))
+	if err != nil {
+		return err
+	}
+	c.setWord(in.regs[0], (min+uint64(val))&c.mask())
+	return nil
+}
+
+func (c *CPU) jumpOp(in *instruction) error {
+	c.ip = int(in.value)
+	return nil
+}
+
+func (c *CPU) jumpZOp(in *instruction) error {
+	if c.flags.z {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpNZOp(in *instruction) error {
+	if !c.flags.z {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpCOp(in *instruction) error {
+	if c.flags.c {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpNCOp(in *instruction) error {
+	if !c.flags.c {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpOOp(in *instruction) error {
+	if c.flags.o {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpNOOp(in *instruction) error {
+	if !c.flags.o {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpNOp(in *instruction) error {
+	if c.flags.s {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpNNOp(in *instruction) error {
+	if !c.flags.s {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpLTOp(in *instruction) error {
+	if c.less() {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpLEOp(in *instruction) error {
+	if c.less() || c.flags.z {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpGTOp(in *instruction) error {
+	if !c.less() && !c.flags.z {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) jumpGEOp(in *instruction) error {
+	if !c.less() {
+		c.ip = int(in.value)
+	}
+	return nil
+}
+
+func (c *CPU) xorOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.saturating {
+		c.setWord(in.regs[0], aVal^bVal)
+		return nil
+	}
+	c.setResult(in.regs[0], aVal^bVal, false, false)
+	return nil
+}
+
+func (c *CPU) addOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+
+	r, carry := c.add(aVal, bVal)
+	if c.saturating {
+		if carry {
+			r = c.mask()
 		}
+		c.setWord(in.regs[0], r)
+		return nil
+	}
+	c.setResult(in.regs[0], r, carry, c.addOverflows(aVal, bVal, r))
+	return nil
+}
 
-		op := opcode.NewOpcode(c.mem[c.ip])
-		debugPrintf("%04X %02X [%s]\n", c.ip, op.Value(), op.String())
+func (c *CPU) subOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.saturating {
+		if aVal < bVal {
+			c.setWord(in.regs[0], 0)
+		} else {
+			c.setWord(in.regs[0], aVal-bVal)
+		}
 
-		//
-		// We've been given a context, which we'll test at every
-		// iteration of our main-loop.
-		//
-		// This is a little slow and inefficient, but we need
-		// to allow our execution to be time-limited.
-		//
-		select {
-		case <-c.context.Done():
-			return fmt.Errorf("timeout during execution")
-		default:
-			// nop
+		// set the zero-flag if the result was zero or less, but
+		// never clear it
+		if aVal <= bVal {
+			c.flags.z = true
+		}
+		return nil
+	}
+
+	r := (aVal - bVal) & c.mask()
+	c.setResult(in.regs[0], r, aVal < bVal, c.subOverflows(aVal, bVal, r))
+	return nil
+}
+
+func (c *CPU) mulOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+
+	r, carry := c.mul(aVal, bVal)
+	if c.saturating {
+		if carry {
+			r = c.mask()
 		}
+		c.setWord(in.regs[0], r)
+		return nil
+	}
+	c.setResult(in.regs[0], r, carry, c.mulOverflows(aVal, bVal))
+	return nil
+}
 
-		switch int(op.Value()) {
-		case opcode.EXIT:
-			run = false
-
-		case opcode.INT_STORE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-			val := c.read2Val()
-			c.regs[reg].SetInt(val)
-
-		case opcode.INT_PRINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			val, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-			if val < 256 {
-				_, err = c.STDOUT.WriteString(fmt.Sprintf("%02X", val))
-				if err != nil {
-					return err
-				}
-			} else {
-				_, err = c.STDOUT.WriteString(fmt.Sprintf("%04X", val))
-				if err != nil {
-					return err
-				}
-
-			}
-			c.STDOUT.Flush()
-			c.ip++
-
-		case opcode.INT_TOSTRING:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// get value
-			i, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-
-			// change from int to string
-			c.regs[reg].SetString(fmt.Sprintf("%d", i))
-
-			// next instruction
-			c.ip++
-
-		case opcode.INT_RANDOM:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// New random source
-			s1 := rand.NewSource(time.Now().UnixNano())
-			r1 := rand.New(s1)
-
-			// New random number
-			c.regs[reg].SetInt(r1.Intn(0xffff))
-			c.ip++
-
-		case opcode.JUMP_TO:
-			c.ip++
-			addr := c.read2Val()
-			c.ip = addr
-
-		case opcode.JUMP_Z:
-			c.ip++
-			addr := c.read2Val()
-			if c.flags.z {
-				c.ip = addr
-			}
-
-		case opcode.JUMP_NZ:
-			c.ip++
-			addr := c.read2Val()
-			if !c.flags.z {
-				c.ip = addr
-			}
-
-		case opcode.XOR_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal ^ bVal)
-
-		case opcode.ADD_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal + bVal)
-
-		case opcode.SUB_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal - bVal)
-
-			// set the zero-flag if the result was zero or less
-			rVal, err := c.regs[res].GetInt()
-			if err != nil {
-				return err
-			}
-			if rVal <= 0 {
-				c.flags.z = true
-			}
-
-		case opcode.MUL_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal * bVal)
-
-		case opcode.DIV_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-
-			if bVal == 0 {
-				return fmt.Errorf("attempted division by zero")
-			}
-			c.regs[res].SetInt(aVal / bVal)
-
-		case opcode.INC_OP:
-
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// get the value
-			val, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-
-			// if the value is the max it will wrap around
-			if val == 0xFFFF {
-				val = 0
-			} else {
-				// otherwise be incremented normally
-				val++
-			}
-
-			// zero?
-			c.flags.z = (val == 0)
-
-			c.regs[reg].SetInt(val)
-
-			// bump past that
-			c.ip++
-
-		case opcode.DEC_OP:
-
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// get the value
-			val, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-
-			// if the value is the minimum it will wrap around
-			if val == 0x0000 {
-				val = 0xFFFF
-			} else {
-				// otherwise decrease normally
-				val--
-			}
-
-			// zero?
-			c.flags.z = (val == 0)
-
-			c.regs[reg].SetInt(val)
-
-			// bump past that
-			c.ip++
-
-		case opcode.AND_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal & bVal)
-
-		case opcode.OR_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			// store result
-			aVal, aErr := c.regs[a].GetInt()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetInt()
-			if bErr != nil {
-				return bErr
-			}
-			c.regs[res].SetInt(aVal | bVal)
-
-		case opcode.STRING_STORE:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// bump past that to the length + string
-			c.ip++
-
-			// read it
-			str, err := c.readString()
-			if err != nil {
-				return err
-			}
-
-			// store the string
-			c.regs[reg].SetString(str)
-
-		case opcode.STRING_PRINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			str, err := c.regs[reg].GetString()
-			if err != nil {
-				return err
-			}
-			_, err = c.STDOUT.WriteString(str)
-			if err != nil {
-				return err
-			}
-
-			c.STDOUT.Flush()
-			c.ip++
-
-		case opcode.STRING_CONCAT:
-			// output register
-			c.ip++
-			res := c.mem[c.ip]
-
-			// src1
-			c.ip++
-			a := c.mem[c.ip]
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-
-			// src2
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-
-			c.ip++
-
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", a)
-			}
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", b)
-			}
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", res)
-			}
-
-			aVal, aErr := c.regs[a].GetString()
-			if aErr != nil {
-				return aErr
-			}
-			bVal, bErr := c.regs[b].GetString()
-			if bErr != nil {
-				return bErr
-			}
-
-			c.regs[res].SetString(aVal + bVal)
-
-		case opcode.STRING_SYSTEM:
-			// register
-			c.ip++
-			r := c.mem[c.ip]
-			c.ip++
-
-			if int(r) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", r)
-			}
-
-			str, sErr := c.regs[r].GetString()
-			if sErr != nil {
-				return sErr
-			}
-
-			if false {
-				// run the command
-				toExec := splitCommand(str)
-				cmd := exec.Command(toExec[0], toExec[1:]...)
-
-				var out bytes.Buffer
-				var err bytes.Buffer
-				cmd.Stdout = &out
-				cmd.Stderr = &err
-				er := cmd.Run()
-				if er != nil {
-					return fmt.Errorf("error invoking system(%s): %s", str, er)
-				}
-
-				// stdout
-				fmt.Printf("%s", out.String())
-
-				// stderr - if non-empty
-				if len(err.String()) > 0 {
-					fmt.Printf("%s", err.String())
-				}
-			}
-		case opcode.STRING_TOINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			// get value
-			s, sErr := c.regs[reg].GetString()
-			if sErr != nil {
-				return sErr
-			}
-
-			i, err := strconv.Atoi(s)
-			if err == nil {
-				c.regs[reg].SetInt(i)
-			} else {
-				return fmt.Errorf("failed to convert %s to int:%s", s, err)
-			}
-
-			// next instruction
-			c.ip++
-
-		case opcode.CMP_REG:
-			c.ip++
-			r1 := int(c.mem[c.ip])
-			c.ip++
-			r2 := int(c.mem[c.ip])
-			c.ip++
-
-			if int(r1) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", r1)
-			}
-			if int(r2) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", r2)
-			}
-
-			c.flags.z = false
-
-			switch c.regs[r1].Type() {
-			case "int":
-
-				aVal, aErr := c.regs[r1].GetInt()
-				if aErr != nil {
-					return aErr
-				}
-				bVal, bErr := c.regs[r2].GetInt()
-				if bErr != nil {
-					return bErr
-				}
-
-				if aVal == bVal {
-					c.flags.z = true
-				}
-			case "string":
-
-				aVal, aErr := c.regs[r1].GetString()
-				if aErr != nil {
-					return aErr
-				}
-				bVal, bErr := c.regs[r2].GetString()
-				if bErr != nil {
-					return bErr
-				}
-
-				if aVal == bVal {
-					c.flags.z = true
-				}
-			}
-
-		case opcode.CMP_IMMEDIATE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-			val := c.read2Val()
-
-			if c.regs[reg].Type() == "int" {
-				valCur, err := c.regs[reg].GetInt()
-				if err != nil {
-					return err
-				}
-				if valCur == val {
-					c.flags.z = true
-				}
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.CMP_STRING:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-
-			// read it
-			str, err := c.readString()
-			if err != nil {
-				return err
-			}
-
-			if c.regs[reg].Type() == "string" {
-				val, err := c.regs[reg].GetString()
-				if err != nil {
-					return err
-				}
-				if val == str {
-					c.flags.z = true
-				}
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.IS_STRING:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "string" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.IS_INTEGER:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "int" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.NOP_OP:
-			c.ip++
-
-		case opcode.REG_STORE:
-			// register
-			c.ip++
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			// register
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			if int(src) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", src)
-			}
-			if int(dst) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", dst)
-			}
-
-			// Copy the register - paying attention to types
-			if c.regs[src].Type() == "string" {
-				cur, err := c.regs[src].GetString()
-				if err != nil {
-					return err
-				}
-
-				c.regs[dst].SetString(cur)
-			} else if c.regs[src].Type() == "int" {
-				cur, err := c.regs[src].GetInt()
-				if err != nil {
-					return err
-				}
-				c.regs[dst].SetInt(cur)
-			} else {
-				return fmt.Errorf("invalid register type?")
-			}
-
-		case opcode.PEEK:
-			// register
-			c.ip++
-			result := int(c.mem[c.ip])
-
-			c.ip++
-			src := int(c.mem[c.ip])
-
-			if int(src) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", src)
-			}
-			if int(result) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", result)
-			}
-
-			// get the address from the src register contents
-			addr, err := c.regs[src].GetInt()
-			if err != nil {
-				return err
-			}
-
-			if addr >= 0xFFFF {
-				return fmt.Errorf("address out of range %d", addr)
-			}
-
-			// store the contents of the given address
-			c.regs[result].SetInt(int(c.mem[addr]))
-			c.ip++
-
-		case opcode.POKE:
-
-			// register
-			c.ip++
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			if int(src) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", src)
-			}
-			if int(dst) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", dst)
-			}
-
-			// So the destination will contain an address
-			// put the contents of the source to that.
-			addr, err := c.regs[dst].GetInt()
-			if err != nil {
-				return err
-			}
-
-			if addr >= 0xFFFF {
-				return fmt.Errorf("address out of range %d", addr)
-			}
-
-			val, err2 := c.regs[src].GetInt()
-			if err2 != nil {
-				return err2
-			}
-
-			if addr >= 0xffff {
-				return fmt.Errorf("attempting to write beyond RAM")
-			}
-			c.mem[addr] = byte(val)
-
-		case opcode.MEMCPY:
-			// register
-			c.ip++
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			ln := int(c.mem[c.ip])
-			c.ip++
-
-			if int(src) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", src)
-			}
-			if int(dst) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", dst)
-			}
-			if int(ln) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", ln)
-			}
-
-			// get the addresses from the registers
-			srcAddr, sErr := c.regs[src].GetInt()
-			if sErr != nil {
-				return sErr
-			}
-			dstAddr, dErr := c.regs[dst].GetInt()
-			if dErr != nil {
-				return dErr
-			}
-			length, lErr := c.regs[ln].GetInt()
-			if lErr != nil {
-				return lErr
-			}
-
-			i := 0
-			for i < length {
-
-				if dstAddr >= 0xFFFF {
-					dstAddr = 0
-				}
-				if srcAddr >= 0xFFFF {
-					srcAddr = 0
-				}
-
-				c.mem[dstAddr] = c.mem[srcAddr]
-				dstAddr++
-				srcAddr++
-				i++
-			}
-
-		case opcode.STACK_PUSH:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-
-			// Store the value in the register on the stack
-			cur, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-			c.stack.Push(cur)
-
-		case opcode.STACK_POP:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) >= len(c.regs) {
-				return fmt.Errorf("register %d out of range", reg)
-			}
-
-			c.ip++
-
-			// Ensure our stack isn't empty
-			if c.stack.Empty() {
-				return fmt.Errorf("stackunderflow")
-			}
-			// Store the value in the register on the stack
-			val, _ := c.stack.Pop()
-			c.regs[reg].SetInt(val)
-
-		case opcode.STACK_RET:
-			// Ensure our stack isn't empty
-			if c.stack.Empty() {
-				return fmt.Errorf("stackunderflow")
-			}
-
-			// Get the address
-			addr, _ := c.stack.Pop()
-
-			// jump
-			c.ip = addr
-
-		case opcode.STACK_CALL:
-			c.ip++
-
-			addr := c.read2Val()
-
-			// push the current IP onto the stack
-			c.stack.Push(c.ip)
-
-			// jump to the call address
-			c.ip = addr
-
-		case opcode.TRAP_OP:
-			c.ip++
-
-			num := c.read2Val()
-
-			if num < 0 || num >= 0xffff {
-				return fmt.Errorf("invalid trap number %d", num)
-			}
-
-			fn := TRAPS[num]
-			if fn != nil {
-				err := fn(c, num)
-				if err != nil {
-					return err
-				}
-			}
-		default:
-			return fmt.Errorf("unrecognized/Unimplemented opcode %02X at IP %04X", op.Value(), c.ip)
+func (c *CPU) divOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if bVal == 0 {
+		return fmt.Errorf("attempted division by zero")
+	}
+	if c.saturating {
+		c.setWord(in.regs[0], aVal/bVal)
+		return nil
+	}
+	if !c.signed {
+		c.setResult(in.regs[0], aVal/bVal, false, false)
+		return nil
+	}
+
+	// The only signed division which overflows is that of the most
+	// negative value, such as -32768, by -1.
+	r := c.integer(aVal) / c.integer(bVal)
+	c.setResult(in.regs[0], uint64(r), false, aVal == c.top() && bVal == c.mask())
+	return nil
+}
+
+func (c *CPU) incOp(in *instruction) error {
+	val, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+
+	if c.saturating {
+		// if the value is the max it will wrap around
+		if val == c.mask() {
+			val = 0
+		} else {
+			// otherwise be incremented normally
+			val++
+		}
+
+		// zero?
+		c.flags.z = (val == 0)
+
+		c.setWord(in.regs[0], val)
+		return nil
+	}
+
+	// The carry-flag is unchanged.
+	c.setResult(in.regs[0], val+1, c.flags.c, val == c.top()-1)
+	return nil
+}
+
+func (c *CPU) decOp(in *instruction) error {
+	val, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+
+	if c.saturating {
+		// if the value is the minimum it will wrap around
+		if val == 0 {
+			val = c.mask()
+		} else {
+			// otherwise decrease normally
+			val--
+		}
+
+		// zero?
+		c.flags.z = (val == 0)
+
+		c.setWord(in.regs[0], val)
+		return nil
+	}
+
+	// The carry-flag is unchanged.
+	c.setResult(in.regs[0], val-1, c.flags.c, val == c.top())
+	return nil
+}
+
+func (c *CPU) andOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.saturating {
+		c.setWord(in.regs[0], aVal&bVal)
+		return nil
+	}
+	c.setResult(in.regs[0], aVal&bVal, false, false)
+	return nil
+}
+
+func (c *CPU) orOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.saturating {
+		c.setWord(in.regs[0], aVal|bVal)
+		return nil
+	}
+	c.setResult(in.regs[0], aVal|bVal, false, false)
+	return nil
+}
+
+func (c *CPU) shlOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	width := uint64(c.width)
+
+	if c.saturating {
+		// if any bits would be lost the result is clamped
+		if aVal != 0 && (bVal >= width || aVal>>(width-bVal) != 0) {
+			c.setWord(in.regs[0], c.mask())
+		} else {
+			c.setWord(in.regs[0], aVal<<bVal)
+		}
+		return nil
+	}
+
+	// The carry-flag receives the last bit shifted out.
+	carry := bVal >= 1 && bVal <= width && (aVal>>(width-bVal))&1 == 1
+	c.setResult(in.regs[0], aVal<<bVal, carry, false)
+	return nil
+}
+
+func (c *CPU) shrOp(in *instruction) error {
+	aVal, bVal, err := c.words(in.regs[1], in.regs[2])
+	if err != nil {
+		return err
+	}
+	if c.saturating {
+		c.setWord(in.regs[0], aVal>>bVal)
+		return nil
+	}
+	if bVal > uint64(c.width) {
+		bVal = uint64(c.width)
+	}
+
+	// In signed mode this is an arithmetic shift, which preserves
+	// the sign of the value.
+	if c.signed {
+		a := c.integer(aVal)
+		carry := bVal >= 1 && (a>>(bVal-1))&1 == 1
+		c.setResult(in.regs[0], uint64(a>>bVal), carry, false)
+		return nil
+	}
+
+	// The carry-flag receives the last bit shifted out.
+	carry := bVal >= 1 && (aVal>>(bVal-1))&1 == 1
+	c.setResult(in.regs[0], aVal>>bVal, carry, false)
+	return nil
+}
+
+func (c *CPU) stringStoreOp(in *instruction) error {
+	return c.setString(in.regs[0], in.str)
+}
+
+func (c *CPU) stringPrintOp(in *instruction) error {
+	str, err := c.regs[in.regs[0]].GetString()
+	if err != nil {
+		return err
+	}
+	return c.write(str)
+}
+
+func (c *CPU) stringConcatOp(in *instruction) error {
+	aVal, err := c.regs[in.regs[1]].GetString()
+	if err != nil {
+		return err
+	}
+	bVal, err := c.regs[in.regs[2]].GetString()
+	if err != nil {
+		return err
+	}
+	return c.setString(in.regs[0], aVal+bVal)
+}
+
+func (c *CPU) stringSystemOp(in *instruction) error {
+	str, err := c.regs[in.regs[0]].GetString()
+	if err != nil {
+		return err
+	}
+
+	if false {
+		// run the command
+		toExec := splitCommand(str)
+		cmd := exec.Command(toExec[0], toExec[1:]...)
+
+		var out bytes.Buffer
+		var err bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &err
+		er := cmd.Run()
+		if er != nil {
+			return fmt.Errorf("error invoking system(%s): %s", str, er)
 		}
 
-		// Ensure our instruction-pointer wraps around.
-		if c.ip > 0xFFFF {
-			c.ip = 0
+		// stdout
+		fmt.Printf("%s", out.String())
+
+		// stderr - if non-empty
+		if len(err.String()) > 0 {
+			fmt.Printf("%s", err.String())
 		}
 	}
+	return nil
+}
+
+func (c *CPU) stringToIntOp(in *instruction) error {
+	s, err := c.regs[in.regs[0]].GetString()
+	if err != nil {
+		return err
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s to int:%s", s, err)
+	}
+	c.setNumber(in.regs[0], i)
+	return nil
+}
+
+func (c *CPU) cmpRegOp(in *instruction) error {
+	r1, r2 := in.regs[0], in.regs[1]
 
+	switch c.regs[r1].Type() {
+	case "int":
+		aVal, bVal, err := c.words(r1, r2)
+		if err != nil {
+			return err
+		}
+		c.compareInts(aVal, bVal)
+	case "string":
+		aVal, err := c.regs[r1].GetString()
+		if err != nil {
+			return err
+		}
+		bVal, err := c.regs[r2].GetString()
+		if err != nil {
+			return err
+		}
+		c.compareStrings(aVal, bVal)
+	}
+	return nil
+}
+
+func (c *CPU) cmpImmediateOp(in *instruction) error {
+	reg := &c.regs[in.regs[0]]
+	if reg.Type() != "int" {
+		c.flags = Flags{}
+		return nil
+	}
+
+	val, err := reg.GetWord()
+	if err != nil {
+		return err
+	}
+	c.compareInts(val, uint64(in.value))
+	return nil
+}
+
+func (c *CPU) cmpImmediateWideOp(in *instruction) error {
+	reg := &c.regs[in.regs[0]]
+	if reg.Type() != "int" {
+		c.flags = Flags{}
+		return nil
+	}
+
+	val, err := reg.GetWord()
+	if err != nil {
+		return err
+	}
+	imm, err := c.immediate(in.value)
+	if err != nil {
+		return err
+	}
+	c.compareInts(val, imm)
+	return nil
+}
+
+func (c *CPU) cmpStringOp(in *instruction) error {
+	reg := &c.regs[in.regs[0]]
+	if reg.Type() != "string" {
+		c.flags = Flags{}
+		return nil
+	}
+
+	val, err := reg.GetString()
+	if err != nil {
+		return err
+	}
+	c.compareStrings(val, in.str)
+	return nil
+}
+
+func (c *CPU) isStringOp(in *instruction) error {
+	c.flags.z = (c.regs[in.regs[0]].Type() == "string")
+	return nil
+}
+
+func (c *CPU) isIntegerOp(in *instruction) error {
+	c.flags.z = (c.regs[in.regs[0]].Type() == "int")
+	return nil
+}
+
+func (c *CPU) nopOp(in *instruction) error {
+	return nil
+}
+
+func (c *CPU) regStoreOp(in *instruction) error {
+	dst, src := in.regs[0], in.regs[1]
+
+	// Copy the register - paying attention to types
+	switch c.regs[src].Type() {
+	case "string":
+		cur, err := c.regs[src].GetString()
+		if err != nil {
+			return err
+		}
+		return c.setString(dst, cur)
+	case "int":
+		cur, err := c.regs[src].GetWord()
+		if err != nil {
+			return err
+		}
+		c.setWord(dst, cur)
+		return nil
+	}
+	return fmt.Errorf("invalid register type?")
+}
+
+func (c *CPU) peekOp(in *instruction) error {
+	// get the address from the src register contents
+	addr, err := c.word(in.regs[1])
+	if err != nil {
+		return err
+	}
+
+	if addr >= 0xFFFF {
+		return fmt.Errorf("address out of range %d", addr)
+	}
+
+	// store the contents of the given address
+	c.setInt(in.regs[0], int(c.mem[addr]))
+	return nil
+}
+
+func (c *CPU) pokeOp(in *instruction) error {
+	// So the destination will contain an address
+	// put the contents of the source to that.
+	addr, err := c.word(in.regs[1])
+	if err != nil {
+		return err
+	}
+
+	if addr >= 0xFFFF {
+		return fmt.Errorf("address out of range %d", addr)
+	}
+
+	val, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+
+	c.writeMemory(int(addr), byte(val))
+	return nil
+}
+
+func (c *CPU) memcpyOp(in *instruction) error {
+	// get the addresses from the registers
+	dst, src, err := c.words(in.regs[0], in.regs[1])
+	if err != nil {
+		return err
+	}
+	length, err := c.word(in.regs[2])
+	if err != nil {
+		return err
+	}
+
+	// Wider words may hold values beyond RAM.
+	if dst > 0xFFFF {
+		return fmt.Errorf("address out of range %d", dst)
+	}
+	if src > 0xFFFF {
+		return fmt.Errorf("address out of range %d", src)
+	}
+	if length > 0xFFFF {
+		return fmt.Errorf("length out of range %d", length)
+	}
+
+	dstAddr, srcAddr := int(dst), int(src)
+	for i := 0; i < int(length); i++ {
+		if dstAddr >= 0xFFFF {
+			dstAddr = 0
+		}
+		if srcAddr >= 0xFFFF {
+			srcAddr = 0
+		}
+
+		c.writeMemory(dstAddr, c.mem[srcAddr])
+		dstAddr++
+		srcAddr++
+	}
+	return nil
+}
+
+func (c *CPU) pushOp(in *instruction) error {
+	// Store the value in the register on the stack
+	cur, err := c.word(in.regs[0])
+	if err != nil {
+		return err
+	}
+	return c.push(cur)
+}
+
+func (c *CPU) popOp(in *instruction) error {
+	// Ensure our stack isn't empty
+	if c.stack.Empty() {
+		return fmt.Errorf("stackunderflow")
+	}
+
+	// Store the value in the register on the stack
+	val, _ := c.stack.PopWord()
+	c.setWord(in.regs[0], val&c.mask())
+	return nil
+}
+
+func (c *CPU) retOp(in *instruction) error {
+	// Ensure our stack isn't empty
+	if c.stack.Empty() {
+		return fmt.Errorf("stackunderflow")
+	}
+
+	// Get the address, which might have been pushed from a register
+	// rather than by a call.
+	addr, _ := c.stack.PopWord()
+	if addr >= uint64(len(c.mem)) {
+		return fmt.Errorf("return address out of range %d", addr)
+	}
+
+	for _, o := range c.observers {
+		o.Return(c, c.current, int(addr))
+	}
+
+	// jump
+	c.ip = int(addr)
+	return nil
+}
+
+func (c *CPU) callOp(in *instruction) error {
+	// push the address of the next instruction onto the stack
+	err := c.push(uint64(c.ip))
+	if err != nil {
+		return err
+	}
+
+	for _, o := range c.observers {
+		o.Call(c, c.current, int(in.value))
+	}
+
+	// jump to the call address
+	c.ip = int(in.value)
+	return nil
+}
+
+func (c *CPU) trapOp(in *instruction) error {
+	if in.value < 0 || in.value >= 0xffff {
+		return fmt.Errorf("invalid trap number %d", in.value)
+	}
+	num := int(in.value)
+
+	for _, o := range c.observers {
+		o.Trap(c, num)
+	}
+
+	fn := TRAPS[num]
+	if fn != nil {
+		return fn(c, num)
+	}
 	return nil
 }
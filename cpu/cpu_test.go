@@ -2,10 +2,14 @@ package cpu
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/skx/go.vm/bytecode"
 	"github.com/skx/go.vm/opcode"
 )
 
@@ -659,3 +663,94 @@ func TestRegisterBounds(t *testing.T) {
 
 	}
 }
+
+// TestLoadProgram tests that programs within our container are loaded at
+// the right addresses, and executed from their entry-point.
+func TestLoadProgram(t *testing.T) {
+
+	prog := &bytecode.Program{
+		Version: bytecode.Version,
+		Entry:   0x20,
+		Sections: []bytecode.Section{
+			// Data we'll skip over.
+			{Type: bytecode.Data, Address: 0, Data: []byte{0xFF, 0xFF}},
+
+			// Code which increments register 01, then exits.
+			{Type: bytecode.Code, Address: 0x20, Data: []byte{
+				byte(opcode.INC_OP),
+				01,
+				byte(opcode.EXIT),
+			}},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "cpu")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.raw")
+	err = ioutil.WriteFile(path, prog.Encode(), 0644)
+	if err != nil {
+		t.Fatalf("failed to write program: %s", err)
+	}
+
+	c := NewCPU()
+	err = c.LoadFile(path)
+	if err != nil {
+		t.Fatalf("failed to load program: %s", err)
+	}
+	err = c.Run()
+	if err != nil {
+		t.Fatalf("failed to run program: %s", err)
+	}
+
+	val, err := c.regs[1].GetInt()
+	if err != nil || val != 1 {
+		t.Fatalf("register has the wrong value: %d %v", val, err)
+	}
+
+	// A corrupt program is rejected.
+	data := prog.Encode()
+	data[len(data)-1] ^= 0xFF
+	ioutil.WriteFile(path, data, 0644)
+	err = c.LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+}
+
+// TestSelfModifying tests that a program which modifies an instruction it
+// has already executed sees the change.
+func TestSelfModifying(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes([]byte{
+		// 0000: store #4, 2
+		byte(opcode.INT_STORE), 0x04, 0x02, 0x00,
+		// 0004: store #3, 1
+		byte(opcode.INT_STORE), 0x03, 0x01, 0x00,
+		// 0008: store #1, 0x0006
+		byte(opcode.INT_STORE), 0x01, 0x06, 0x00,
+		// 000C: store #2, 2
+		byte(opcode.INT_STORE), 0x02, 0x02, 0x00,
+		// 0010: poke #2, #1 - changing the instruction at 0004
+		byte(opcode.POKE), 0x02, 0x01,
+		// 0013: dec #4
+		byte(opcode.DEC_OP), 0x04,
+		// 0015: jmpnz 0x0004
+		byte(opcode.JUMP_NZ), 0x04, 0x00,
+		// 0018: exit
+		byte(opcode.EXIT),
+	})
+
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	val, err := c.regs[3].GetInt()
+	if err != nil || val != 2 {
+		t.Fatalf("expected the modified instruction to run, got %d %v", val, err)
+	}
+}
@@ -0,0 +1,162 @@
+// This file contains the decoding of instructions, and the table of
+// handlers which execute them.
+//
+// Rather than parsing the operands of each instruction every time it is
+// executed each instruction is decoded once, when it is first executed,
+// and the result cached by its address.  Writes to RAM which touch the
+// bytes of a decoded instruction, such as via `poke` or `memcpy`, discard
+// the cache so that self-modifying programs continue to work.
+
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// pollInterval is the number of instructions executed between each test
+// of our context.
+const pollInterval = 1024
+
+// handler executes a single decoded instruction.
+type handler func(c *CPU, in *instruction) error
+
+// handlers holds the handler for each opcode, indexed by its value.
+var handlers [256]handler
+
+func init() {
+	handlers[opcode.EXIT] = (*CPU).exitOp
+	handlers[opcode.INT_STORE] = (*CPU).intStoreOp
+	handlers[opcode.INT_PRINT] = (*CPU).intPrintOp
+	handlers[opcode.INT_TOSTRING] = (*CPU).intToStringOp
+	handlers[opcode.INT_RANDOM] = (*CPU).intRandomOp
+	handlers[opcode.INT_RANDOM_RANGE] = (*CPU).intRandomRangeOp
+	handlers[opcode.INT_STORE32] = (*CPU).intStoreWideOp
+	handlers[opcode.INT_STORE64] = (*CPU).intStoreWideOp
+	handlers[opcode.JUMP_TO] = (*CPU).jumpOp
+	handlers[opcode.JUMP_Z] = (*CPU).jumpZOp
+	handlers[opcode.JUMP_NZ] = (*CPU).jumpNZOp
+	handlers[opcode.JUMP_C] = (*CPU).jumpCOp
+	handlers[opcode.JUMP_NC] = (*CPU).jumpNCOp
+	handlers[opcode.JUMP_O] = (*CPU).jumpOOp
+	handlers[opcode.JUMP_NO] = (*CPU).jumpNOOp
+	handlers[opcode.JUMP_N] = (*CPU).jumpNOp
+	handlers[opcode.JUMP_NN] = (*CPU).jumpNNOp
+	handlers[opcode.JUMP_LT] = (*CPU).jumpLTOp
+	handlers[opcode.JUMP_LE] = (*CPU).jumpLEOp
+	handlers[opcode.JUMP_GT] = (*CPU).jumpGTOp
+	handlers[opcode.JUMP_GE] = (*CPU).jumpGEOp
+	handlers[opcode.XOR_OP] = (*CPU).xorOp
+	handlers[opcode.ADD_OP] = (*CPU).addOp
+	handlers[opcode.SUB_OP] = (*CPU).subOp
+	handlers[opcode.MUL_OP] = (*CPU).mulOp
+	handlers[opcode.DIV_OP] = (*CPU).divOp
+	handlers[opcode.INC_OP] = (*CPU).incOp
+	handlers[opcode.DEC_OP] = (*CPU).decOp
+	handlers[opcode.AND_OP] = (*CPU).andOp
+	handlers[opcode.OR_OP] = (*CPU).orOp
+	handlers[opcode.SHL_OP] = (*CPU).shlOp
+	handlers[opcode.SHR_OP] = (*CPU).shrOp
+	handlers[opcode.STRING_STORE] = (*CPU).stringStoreOp
+	handlers[opcode.STRING_PRINT] = (*CPU).stringPrintOp
+	handlers[opcode.STRING_CONCAT] = (*CPU).stringConcatOp
+	handlers[opcode.STRING_SYSTEM] = (*CPU).stringSystemOp
+	handlers[opcode.STRING_TOINT] = (*CPU).stringToIntOp
+	handlers[opcode.CMP_REG] = (*CPU).cmpRegOp
+	handlers[opcode.CMP_IMMEDIATE] = (*CPU).cmpImmediateOp
+	handlers[opcode.CMP_STRING] = (*CPU).cmpStringOp
+	handlers[opcode.IS_STRING] = (*CPU).isStringOp
+	handlers[opcode.IS_INTEGER] = (*CPU).isIntegerOp
+	handlers[opcode.CMP_IMMEDIATE32] = (*CPU).cmpImmediateWideOp
+	handlers[opcode.CMP_IMMEDIATE64] = (*CPU).cmpImmediateWideOp
+	handlers[opcode.NOP_OP] = (*CPU).nopOp
+	handlers[opcode.REG_STORE] = (*CPU).regStoreOp
+	handlers[opcode.PEEK] = (*CPU).peekOp
+	handlers[opcode.POKE] = (*CPU).pokeOp
+	handlers[opcode.MEMCPY] = (*CPU).memcpyOp
+	handlers[opcode.STACK_PUSH] = (*CPU).pushOp
+	handlers[opcode.STACK_POP] = (*CPU).popOp
+	handlers[opcode.STACK_RET] = (*CPU).retOp
+	handlers[opcode.STACK_CALL] = (*CPU).callOp
+	handlers[opcode.TRAP_OP] = (*CPU).trapOp
+}
+
+// instruction is a single decoded instruction.
+type instruction struct {
+	// handler executes the instruction.
+	handler handler
+
+	// next is the address of the following instruction.
+	next int
+
+	// regs holds the register operands, in order.
+	regs [3]int
+
+	// value holds the number, or address, operand.
+	value int64
+
+	// str holds the string operand.
+	str string
+}
+
+// decode decodes the instruction at the given address, and caches the
+// result.
+func (c *CPU) decode(addr int) (*instruction, error) {
+	op := c.mem[addr]
+
+	fn := handlers[op]
+	if fn == nil {
+		return nil, fmt.Errorf("unrecognized/Unimplemented opcode %02X at IP %04X", op, addr)
+	}
+
+	dec, err := opcode.Decode(c.mem[:], addr)
+	if err != nil {
+		return nil, fmt.Errorf("reading beyond RAM")
+	}
+
+	in := &instruction{handler: fn, next: addr + dec.Length}
+
+	// Our register operands are bounds-checked here, once, rather than
+	// every time the instruction is executed.
+	n := 0
+	for i, kind := range dec.Operands {
+		switch kind {
+		case opcode.Register:
+			reg := int(dec.Args[i])
+			if reg >= len(c.regs) {
+				return nil, fmt.Errorf("register %d out of range", reg)
+			}
+			in.regs[n] = reg
+			n++
+		case opcode.String:
+			in.str = string(dec.Data)
+		default:
+			in.value = dec.Args[i]
+		}
+	}
+
+	c.cache[addr] = in
+	for i := addr; i < in.next; i++ {
+		c.code[i] = true
+	}
+	c.cached = true
+	return in, nil
+}
+
+// invalidate discards our decoded instructions if the given address is
+// part of one of them.
+func (c *CPU) invalidate(addr int) {
+	if c.code[addr] {
+		c.flush()
+	}
+}
+
+// flush discards all of our decoded instructions.
+func (c *CPU) flush() {
+	if c.cached {
+		c.cache = [len(c.mem)]*instruction{}
+		c.code = [len(c.mem)]bool{}
+		c.cached = false
+	}
+}
@@ -23,6 +23,8 @@ func FuzzCPU(f *testing.F) {
 		"attempted division by zero",
 		"attempting to call GetInt on a register holding a",
 		"attempting to call GetString on a register holding a",
+		"attempting to call GetWord on a register holding a",
+		"does not fit in a",
 		"error invoking system",
 		"out of range",
 		"stackunderflow",
@@ -31,6 +33,7 @@ func FuzzCPU(f *testing.F) {
 		"too large",
 		"trap function not defined:",
 		"invalid trap ",
+		"invalid random range",
 		"EOF",
 		"write beyond RAM",
 		"reading beyond RAM",
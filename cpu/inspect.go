@@ -0,0 +1,170 @@
+// This file contains the functions which allow the state of the CPU to
+// be examined, and modified, from outside this package.
+//
+// They are used to implement our debugger, which single-steps a program
+// and shows the state of the machine as it does so, and may be used by
+// any host which wishes to do the same.
+
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHalted is returned when attempting to step a program which has
+// already executed `exit`.
+var ErrHalted = errors.New("the program has exited")
+
+// StepResult describes the instruction executed by Step.
+type StepResult struct {
+	// IP is the address of the instruction.
+	IP int
+
+	// Opcode is the opcode of the instruction.
+	Opcode byte
+
+	// Next is the address of the next instruction to be executed.
+	Next int
+
+	// Halted is true if the instruction was `exit`.
+	Halted bool
+}
+
+// Step executes the single instruction at the instruction-pointer, and
+// returns a description of what was done.
+//
+// This allows a host to interleave the execution of a program with
+// its own work, rather than handing control to Run until the program
+// exits.
+//
+// Any error returned will be a *Fault, recording the address of the
+// instruction which failed, or ErrHalted.
+func (c *CPU) Step() (StepResult, error) {
+	if c.halted {
+		return StepResult{IP: c.ip, Next: c.ip, Halted: true}, ErrHalted
+	}
+
+	res := StepResult{IP: c.ip}
+	if c.ip >= 0 && c.ip < len(c.mem) {
+		res.Opcode = c.mem[c.ip]
+	}
+
+	err := c.step()
+	res.Next = c.ip
+	res.Halted = c.halted
+	if err != nil {
+		return res, &Fault{IP: c.current, Err: err}
+	}
+	return res, nil
+}
+
+// Halted returns true if the program has executed `exit`.
+func (c *CPU) Halted() bool {
+	return c.halted
+}
+
+// IP returns the address of the next instruction to be executed.
+func (c *CPU) IP() int {
+	return c.ip
+}
+
+// SetIP changes the address of the next instruction to be executed.
+func (c *CPU) SetIP(addr int) error {
+	if addr < 0 || addr >= len(c.mem) {
+		return fmt.Errorf("address %04X is outside RAM", addr)
+	}
+	c.ip = addr
+	return nil
+}
+
+// Register returns the given register, or nil if there is no such
+// register.
+func (c *CPU) Register(n int) *Register {
+	if n < 0 || n >= len(c.regs) {
+		return nil
+	}
+	return &c.regs[n]
+}
+
+// Signed returns true if integers are treated as signed, as selected by
+// SetSigned.
+func (c *CPU) Signed() bool {
+	return c.signed
+}
+
+// WordSize returns the number of bits in our integers, as selected by
+// SetWordSize.
+func (c *CPU) WordSize() int {
+	return int(c.width)
+}
+
+// ZeroFlag returns the value of the Z-flag.
+func (c *CPU) ZeroFlag() bool {
+	return c.flags.z
+}
+
+// SetZeroFlag changes the value of the Z-flag.
+func (c *CPU) SetZeroFlag(z bool) {
+	c.flags.z = z
+}
+
+// CarryFlag returns the value of the C-flag.
+func (c *CPU) CarryFlag() bool {
+	return c.flags.c
+}
+
+// SetCarryFlag changes the value of the C-flag.
+func (c *CPU) SetCarryFlag(v bool) {
+	c.flags.c = v
+}
+
+// OverflowFlag returns the value of the O-flag.
+func (c *CPU) OverflowFlag() bool {
+	return c.flags.o
+}
+
+// SetOverflowFlag changes the value of the O-flag.
+func (c *CPU) SetOverflowFlag(v bool) {
+	c.flags.o = v
+}
+
+// SignFlag returns the value of the S-flag.
+func (c *CPU) SignFlag() bool {
+	return c.flags.s
+}
+
+// SetSignFlag changes the value of the S-flag.
+func (c *CPU) SetSignFlag(v bool) {
+	c.flags.s = v
+}
+
+// StackEntries returns the contents of the stack, with the most-recently
+// pushed value last.
+func (c *CPU) StackEntries() []int {
+	entries := make([]int, len(c.stack.entries))
+	for i, val := range c.stack.entries {
+		entries[i] = int(val)
+	}
+	return entries
+}
+
+// ReadMemory returns a copy of the given region of RAM.
+func (c *CPU) ReadMemory(addr int, length int) ([]byte, error) {
+	if addr < 0 || length < 0 || addr+length > len(c.mem) {
+		return nil, fmt.Errorf("region %04X+%d is outside RAM", addr, length)
+	}
+	return append([]byte{}, c.mem[addr:addr+length]...), nil
+}
+
+// WriteMemory writes the given bytes to RAM, at the given address.
+func (c *CPU) WriteMemory(addr int, data []byte) error {
+	if addr < 0 || addr+len(data) > len(c.mem) {
+		return fmt.Errorf("region %04X+%d is outside RAM", addr, len(data))
+	}
+	copy(c.mem[addr:], data)
+	for i := range data {
+		c.invalidate(addr + i)
+	}
+	return nil
+}
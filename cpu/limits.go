@@ -0,0 +1,89 @@
+// This file contains the resource limits, which prevent a hostile program
+// from consuming unbounded memory, output, or input.
+//
+// Every push to the stack, string stored in a register, and byte written
+// to STDOUT, is made via the helpers in this file so that the limits are
+// enforced consistently.  The limit upon input is enforced by readLine.
+
+package cpu
+
+import "fmt"
+
+const (
+	// StackDepthLimit is the name of the limit upon the number of
+	// entries on the stack.
+	StackDepthLimit = "stack depth"
+
+	// StringLengthLimit is the name of the limit upon the length of
+	// the string held in a register.
+	StringLengthLimit = "string length"
+
+	// OutputLimit is the name of the limit upon the number of bytes
+	// written to STDOUT.
+	OutputLimit = "output"
+
+	// InputLimit is the name of the limit upon the number of bytes
+	// read from STDIN.
+	InputLimit = "input"
+)
+
+// Limits holds the resource limits of a program.  A value of zero means
+// there is no limit.
+type Limits struct {
+	// StackDepth is the maximum number of entries on the stack.
+	StackDepth int
+
+	// StringLength is the maximum length of the string held in a
+	// single register.
+	StringLength int
+
+	// Output is the maximum number of bytes written to STDOUT.
+	Output int
+
+	// Input is the maximum number of bytes read from STDIN.
+	Input int
+}
+
+// LimitError is the error returned when a program exceeds one of its
+// resource limits.
+type LimitError struct {
+	// Limit is the name of the limit which was exceeded, for example
+	// StackDepthLimit.
+	Limit string
+
+	// Max is the value of the limit.
+	Max int
+}
+
+// Error returns a description of the limit which was exceeded.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// SetLimits sets the resource limits of the program.
+func (c *CPU) SetLimits(limits Limits) {
+	c.limits = limits
+}
+
+// push adds a value to the stack.
+func (c *CPU) push(value uint64) error {
+	if c.limits.StackDepth > 0 && c.stack.Size() >= c.limits.StackDepth {
+		return &LimitError{Limit: StackDepthLimit, Max: c.limits.StackDepth}
+	}
+	c.stack.PushWord(value)
+	return nil
+}
+
+// write writes the given string to STDOUT.
+func (c *CPU) write(str string) error {
+	if c.limits.Output > 0 && c.written+len(str) > c.limits.Output {
+		return &LimitError{Limit: OutputLimit, Max: c.limits.Output}
+	}
+	c.written += len(str)
+
+	_, err := c.STDOUT.WriteString(str)
+	if err != nil {
+		return err
+	}
+	return c.STDOUT.Flush()
+}
@@ -0,0 +1,145 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// TestLimits tests that each limit stops a program which exceeds it.
+func TestLimits(t *testing.T) {
+
+	type TestCase struct {
+		Name    string
+		Limits  Limits
+		Program []byte
+		Input   string
+		IP      int
+	}
+
+	tests := []TestCase{
+		{
+			Name:   "recursion",
+			Limits: Limits{StackDepth: 10},
+			Program: []byte{
+				// 0000: call 0x0000
+				byte(opcode.STACK_CALL), 0x00, 0x00,
+			},
+			IP: 0,
+		},
+		{
+			Name:   "push",
+			Limits: Limits{StackDepth: 2},
+			Program: []byte{
+				byte(opcode.STACK_PUSH), 0x01,
+				byte(opcode.STACK_PUSH), 0x01,
+				byte(opcode.STACK_PUSH), 0x01,
+			},
+			IP: 4,
+		},
+		{
+			Name:   "concat",
+			Limits: Limits{StringLength: 8},
+			Program: []byte{
+				// 0000: store #1, "ab"
+				byte(opcode.STRING_STORE), 0x01, 0x02, 0x00, 'a', 'b',
+				// 0006: concat #1, #1, #1
+				byte(opcode.STRING_CONCAT), 0x01, 0x01, 0x01,
+				// 000A: jmp 0x0006
+				byte(opcode.JUMP_TO), 0x06, 0x00,
+			},
+			IP: 6,
+		},
+		{
+			Name:   "store",
+			Limits: Limits{StringLength: 1},
+			Program: []byte{
+				byte(opcode.STRING_STORE), 0x01, 0x02, 0x00, 'a', 'b',
+			},
+			IP: 0,
+		},
+		{
+			Name:   "output",
+			Limits: Limits{Output: 5},
+			Program: []byte{
+				// 0000: store #1, "abc"
+				byte(opcode.STRING_STORE), 0x01, 0x03, 0x00, 'a', 'b', 'c',
+				// 0007: print_str #1
+				byte(opcode.STRING_PRINT), 0x01,
+				// 0009: jmp 0x0007
+				byte(opcode.JUMP_TO), 0x07, 0x00,
+			},
+			IP: 7,
+		},
+		{
+			Name:   "input",
+			Limits: Limits{Input: 6},
+			Program: []byte{
+				// 0000: int 0x0001
+				byte(opcode.TRAP_OP), 0x01, 0x00,
+				// 0003: jmp 0x0000
+				byte(opcode.JUMP_TO), 0x00, 0x00,
+			},
+			Input: "one\ntwo\nthree\n",
+			IP:    0,
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+
+		c := NewCPU()
+		c.SetLimits(test.Limits)
+		c.STDIN = bufio.NewReader(strings.NewReader(test.Input))
+		c.STDOUT = bufio.NewWriter(&out)
+		c.LoadBytes(test.Program)
+
+		err := c.Run()
+		fault, ok := err.(*Fault)
+		if !ok {
+			t.Fatalf("%s: expected a fault, got %v", test.Name, err)
+		}
+		limit, ok := fault.Err.(*LimitError)
+		if !ok {
+			t.Fatalf("%s: expected a limit to be exceeded, got %v", test.Name, fault.Err)
+		}
+		if fault.IP != test.IP {
+			t.Fatalf("%s: expected the fault at %04X, got %04X", test.Name, test.IP, fault.IP)
+		}
+
+		// Ensure the limit was enforced exactly.
+		switch limit.Limit {
+		case StackDepthLimit:
+			if c.stack.Size() != test.Limits.StackDepth {
+				t.Fatalf("%s: unexpected stack depth %d", test.Name, c.stack.Size())
+			}
+		case StringLengthLimit:
+			str, _ := c.regs[1].GetString()
+			if len(str) > test.Limits.StringLength {
+				t.Fatalf("%s: unexpected string %q", test.Name, str)
+			}
+		case OutputLimit:
+			if out.String() != "abc" {
+				t.Fatalf("%s: unexpected output %q", test.Name, out.String())
+			}
+		case InputLimit:
+			str, _ := c.regs[0].GetString()
+			if str != "one\n" || c.read != 6 {
+				t.Fatalf("%s: unexpected input %q, %d", test.Name, str, c.read)
+			}
+		default:
+			t.Fatalf("%s: unexpected limit %s", test.Name, limit.Limit)
+		}
+	}
+}
+
+// TestLimitError tests the description of an exceeded limit.
+func TestLimitError(t *testing.T) {
+	err := &LimitError{Limit: StackDepthLimit, Max: 100}
+	if err.Error() != "stack depth limit of 100 exceeded" {
+		t.Fatalf("unexpected message: %s", err)
+	}
+}
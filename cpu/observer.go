@@ -0,0 +1,123 @@
+// This file contains the observers, which are notified as a program
+// executes.
+//
+// Observers allow tracers, profilers, and debuggers to be built outside
+// this package, without needing to modify the main-loop.  Every change
+// the program makes to a register, or to RAM, is made via the helpers
+// in this file so that no change goes unreported.
+
+package cpu
+
+// Observer is the interface for an object which is notified as the CPU
+// executes a program.
+//
+// The callbacks are invoked synchronously, from the main-loop, so they
+// should be quick.  They may inspect the state of the CPU, but must not
+// modify it.
+type Observer interface {
+	// BeforeInstruction is called before the instruction at the given
+	// address is executed.
+	BeforeInstruction(c *CPU, ip int, op byte)
+
+	// Trap is called before the given trap is invoked.
+	Trap(c *CPU, num int)
+
+	// MemoryWrite is called after the program has written the given
+	// value to RAM.
+	MemoryWrite(c *CPU, addr int, value byte)
+
+	// RegisterWrite is called after the program has changed the
+	// contents of the given register.
+	RegisterWrite(c *CPU, reg int, value *Register)
+
+	// Call is called when the instruction at `from` calls the
+	// subroutine at `to`.
+	Call(c *CPU, from int, to int)
+
+	// Return is called when the instruction at `from` returns to the
+	// address `to`.
+	Return(c *CPU, from int, to int)
+}
+
+// NopObserver implements Observer, ignoring every notification.
+//
+// It may be embedded by observers which are only interested in some
+// of the notifications.
+type NopObserver struct{}
+
+// BeforeInstruction implements Observer.
+func (NopObserver) BeforeInstruction(c *CPU, ip int, op byte) {}
+
+// Trap implements Observer.
+func (NopObserver) Trap(c *CPU, num int) {}
+
+// MemoryWrite implements Observer.
+func (NopObserver) MemoryWrite(c *CPU, addr int, value byte) {}
+
+// RegisterWrite implements Observer.
+func (NopObserver) RegisterWrite(c *CPU, reg int, value *Register) {}
+
+// Call implements Observer.
+func (NopObserver) Call(c *CPU, from int, to int) {}
+
+// Return implements Observer.
+func (NopObserver) Return(c *CPU, from int, to int) {}
+
+// AddObserver registers an observer, which will be notified as the
+// program executes.
+func (c *CPU) AddObserver(o Observer) {
+	c.observers = append(c.observers, o)
+}
+
+// RemoveObserver removes an observer previously registered with
+// AddObserver.
+func (c *CPU) RemoveObserver(o Observer) {
+	for i, cur := range c.observers {
+		if cur == o {
+			c.observers = append(c.observers[:i:i], c.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// setInt stores an integer in the given register, clamping it to the
+// range of a word.
+func (c *CPU) setInt(reg int, value int) {
+	if value < 0 {
+		value = 0
+	} else if uint64(value) > c.mask() {
+		value = int(c.mask())
+	}
+	c.setWord(reg, uint64(value))
+}
+
+// setWord stores a word in the given register.
+func (c *CPU) setWord(reg int, value uint64) {
+	c.regs[reg].SetWord(value)
+	for _, o := range c.observers {
+		o.RegisterWrite(c, reg, &c.regs[reg])
+	}
+}
+
+// setString stores a string in the given register, if it is within
+// the limit upon string length.
+func (c *CPU) setString(reg int, value string) error {
+	if c.limits.StringLength > 0 && len(value) > c.limits.StringLength {
+		return &LimitError{Limit: StringLengthLimit, Max: c.limits.StringLength}
+	}
+
+	c.regs[reg].SetString(value)
+	for _, o := range c.observers {
+		o.RegisterWrite(c, reg, &c.regs[reg])
+	}
+	return nil
+}
+
+// writeMemory stores a byte in RAM.
+func (c *CPU) writeMemory(addr int, value byte) {
+	c.mem[addr] = value
+	c.invalidate(addr)
+	for _, o := range c.observers {
+		o.MemoryWrite(c, addr, value)
+	}
+}
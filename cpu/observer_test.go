@@ -0,0 +1,177 @@
+package cpu
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// program calls a subroutine which invokes a trap, then writes the
+// result to RAM.
+var program = []byte{
+	// 0000: store #2, 0x0100
+	byte(opcode.INT_STORE), 0x02, 0x00, 0x01,
+	// 0004: call 0x000B
+	byte(opcode.STACK_CALL), 0x0B, 0x00,
+	// 0007: poke #0, #2
+	byte(opcode.POKE), 0x00, 0x02,
+	// 000A: exit
+	byte(opcode.EXIT),
+	// 000B: store #0, "hi"
+	byte(opcode.STRING_STORE), 0x00, 0x02, 0x00, 'h', 'i',
+	// 0011: int 0x0000 - strlen
+	byte(opcode.TRAP_OP), 0x00, 0x00,
+	// 0014: ret
+	byte(opcode.STACK_RET),
+}
+
+// recorder is an observer which records each notification.
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) BeforeInstruction(c *CPU, ip int, op byte) {
+	r.events = append(r.events, fmt.Sprintf("%04X %02X", ip, op))
+}
+
+func (r *recorder) Trap(c *CPU, num int) {
+	r.events = append(r.events, fmt.Sprintf("trap %d", num))
+}
+
+func (r *recorder) MemoryWrite(c *CPU, addr int, value byte) {
+	r.events = append(r.events, fmt.Sprintf("mem %04X=%02X", addr, value))
+}
+
+func (r *recorder) RegisterWrite(c *CPU, reg int, value *Register) {
+	if value.Type() == "string" {
+		str, _ := value.GetString()
+		r.events = append(r.events, fmt.Sprintf("reg #%d=%q", reg, str))
+	} else {
+		i, _ := value.GetInt()
+		r.events = append(r.events, fmt.Sprintf("reg #%d=%d", reg, i))
+	}
+}
+
+func (r *recorder) Call(c *CPU, from int, to int) {
+	r.events = append(r.events, fmt.Sprintf("call %04X->%04X", from, to))
+}
+
+func (r *recorder) Return(c *CPU, from int, to int) {
+	r.events = append(r.events, fmt.Sprintf("ret %04X->%04X", from, to))
+}
+
+// calls counts calls, and nothing else.
+type calls struct {
+	NopObserver
+	count int
+}
+
+func (c *calls) Call(cpu *CPU, from int, to int) {
+	c.count++
+}
+
+// TestStep tests executing a program one instruction at a time.
+func TestStep(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(program)
+
+	expected := []StepResult{
+		{IP: 0x0000, Opcode: byte(opcode.INT_STORE), Next: 0x0004},
+		{IP: 0x0004, Opcode: byte(opcode.STACK_CALL), Next: 0x000B},
+		{IP: 0x000B, Opcode: byte(opcode.STRING_STORE), Next: 0x0011},
+		{IP: 0x0011, Opcode: byte(opcode.TRAP_OP), Next: 0x0014},
+		{IP: 0x0014, Opcode: byte(opcode.STACK_RET), Next: 0x0007},
+		{IP: 0x0007, Opcode: byte(opcode.POKE), Next: 0x000A},
+		{IP: 0x000A, Opcode: byte(opcode.EXIT), Next: 0x000A, Halted: true},
+	}
+	for i, want := range expected {
+		got, err := c.Step()
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %s", i, err)
+		}
+		if got != want {
+			t.Fatalf("step %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	if mem, _ := c.ReadMemory(0x0100, 1); mem[0] != 2 {
+		t.Fatalf("expected the result to be written to RAM, got %02X", mem[0])
+	}
+
+	_, err := c.Step()
+	if err != ErrHalted {
+		t.Fatalf("expected ErrHalted, got %v", err)
+	}
+}
+
+// TestStepFault tests the error returned when an instruction fails.
+func TestStepFault(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes([]byte{byte(opcode.NOP_OP), byte(opcode.STACK_RET)})
+
+	_, err := c.Step()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := c.Step()
+	fault, ok := err.(*Fault)
+	if !ok {
+		t.Fatalf("expected a fault, got %v", err)
+	}
+	if fault.IP != 1 || res.IP != 1 || res.Halted {
+		t.Fatalf("unexpected result %+v: %s", res, fault)
+	}
+}
+
+// TestObserver tests that observers are notified of each event.
+func TestObserver(t *testing.T) {
+	r := &recorder{}
+	n := &calls{}
+
+	c := NewCPU()
+	c.LoadBytes(program)
+	c.AddObserver(r)
+	c.AddObserver(n)
+
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{
+		"0000 01",
+		"reg #2=256",
+		"0004 73",
+		"call 0004->000B",
+		"000B 30",
+		`reg #0="hi"`,
+		"0011 80",
+		"trap 0",
+		"reg #0=2",
+		"0014 72",
+		"ret 0014->0007",
+		"0007 61",
+		"mem 0100=02",
+		"000A 00",
+	}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Fatalf("unexpected events:\n%q\nexpected:\n%q", r.events, expected)
+	}
+	if n.count != 1 {
+		t.Fatalf("expected one call, got %d", n.count)
+	}
+
+	// Once removed an observer is no longer notified.
+	c.RemoveObserver(r)
+	c.Reset()
+	r.events = nil
+	err = c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(r.events) != 0 || n.count != 2 {
+		t.Fatalf("unexpected notifications %q, %d", r.events, n.count)
+	}
+}
@@ -0,0 +1,66 @@
+// This file contains the source of the random numbers generated by the
+// `random` instruction.
+//
+// By default the source is seeded from the current time, but it may be
+// replaced so that tests, and the programs being run, are reproducible.
+//
+// A source created from a seed counts the numbers it generates, which
+// allows its state to be recorded within a snapshot.
+
+package cpu
+
+import "math/rand"
+
+// seededSource is a source of random numbers which records its seed,
+// and the count of numbers drawn from it.
+type seededSource struct {
+	src   rand.Source
+	seed  int64
+	drawn uint64
+}
+
+// newSeededSource returns a source with the given seed, from which the
+// given count of numbers has already been drawn.
+func newSeededSource(seed int64, drawn uint64) *seededSource {
+	s := &seededSource{src: rand.NewSource(seed), seed: seed}
+	for s.drawn < drawn {
+		s.Int63()
+	}
+	return s
+}
+
+// Int63 returns the next random number.
+func (s *seededSource) Int63() int64 {
+	s.drawn++
+	return s.src.Int63()
+}
+
+// Seed reseeds the source.
+func (s *seededSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.seed = seed
+	s.drawn = 0
+}
+
+// SetRandomSource sets the source of random numbers.
+//
+// The state of the source isn't recorded by Snapshot, unless it was
+// created via SetSeed.
+func (c *CPU) SetRandomSource(src rand.Source) {
+	c.rng = rand.New(src)
+	c.seeded, _ = src.(*seededSource)
+}
+
+// SetSeed seeds the source of random numbers with the given value, so
+// that the same numbers are generated each time the program is run.
+func (c *CPU) SetSeed(seed int64) {
+	c.SetRandomSource(newSeededSource(seed, 0))
+}
+
+// random returns a random number in the range 0 to max-1.
+func (c *CPU) random(max int) (int, error) {
+	ev, err := c.input(RandomInput, func() Event {
+		return Event{Int: c.rng.Intn(max)}
+	})
+	return ev.Int, err
+}
@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// rangeProgram stores ten random numbers, within the range held in #1
+// and #2, upon the stack.
+var rangeProgram = []byte{
+	// 0000: store #3, 10
+	byte(opcode.INT_STORE), 0x03, 0x0A, 0x00,
+	// 0004: random #4, #1, #2
+	byte(opcode.INT_RANDOM_RANGE), 0x04, 0x01, 0x02,
+	// 0008: push #4
+	byte(opcode.STACK_PUSH), 0x04,
+	// 000A: dec #3
+	byte(opcode.DEC_OP), 0x03,
+	// 000C: jmpnz 0x0004
+	byte(opcode.JUMP_NZ), 0x04, 0x00,
+	// 000F: exit
+	byte(opcode.EXIT),
+}
+
+// roll runs rangeProgram with the given range, returning the numbers
+// generated.
+func roll(t *testing.T, c *CPU, min int, max int) []int {
+	t.Helper()
+
+	c.LoadBytes(rangeProgram)
+	c.regs[1].SetInt(min)
+	c.regs[2].SetInt(max)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return c.StackEntries()
+}
+
+// TestSeed tests that seeding the random numbers makes them repeatable.
+func TestSeed(t *testing.T) {
+	c := NewCPU()
+	c.SetSeed(42)
+	first := roll(t, c, 0, 0xFFFE)
+
+	c.SetSeed(42)
+	second := roll(t, c, 0, 0xFFFE)
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same numbers, got %v and %v", first, second)
+		}
+	}
+
+	// A source may be supplied too.
+	c.SetRandomSource(rand.NewSource(42))
+	third := roll(t, c, 0, 0xFFFE)
+	for i := range first {
+		if first[i] != third[i] {
+			t.Fatalf("expected the same numbers, got %v and %v", first, third)
+		}
+	}
+}
+
+// TestRandomRange tests that random numbers are generated within the
+// given range.
+func TestRandomRange(t *testing.T) {
+	c := NewCPU()
+	c.SetSeed(1)
+
+	for _, r := range [][2]int{{1, 6}, {5, 5}, {0, 1}, {0xFFF0, 0xFFFF}} {
+		seen := make(map[int]bool)
+		for i := 0; i < 10; i++ {
+			for _, n := range roll(t, c, r[0], r[1]) {
+				if n < r[0] || n > r[1] {
+					t.Fatalf("%d is outside the range %d-%d", n, r[0], r[1])
+				}
+				seen[n] = true
+			}
+		}
+
+		// With a hundred numbers we'd expect to see every value.
+		if len(seen) != r[1]-r[0]+1 {
+			t.Fatalf("expected every number in %d-%d, got %v", r[0], r[1], seen)
+		}
+	}
+
+	// An empty range is an error.
+	c.LoadBytes(rangeProgram)
+	c.regs[1].SetInt(6)
+	c.regs[2].SetInt(1)
+	err := c.Run()
+	if err == nil || !strings.Contains(err.Error(), "invalid random range 6-1") {
+		t.Fatalf("expected an invalid range, got %v", err)
+	}
+}
@@ -5,14 +5,28 @@ import (
 	"fmt"
 )
 
+// kind is the type of the contents of a register.
+type kind byte
+
+const (
+	// intKind is the kind of a register holding an integer.
+	intKind kind = iota
+
+	// stringKind is the kind of a register holding a string.
+	stringKind
+)
+
 // Object is the interface for something we store in a register.
 //
-// This is done to allow future expansion, and also for neatness.
+// Deprecated: registers no longer hold their contents as an Object, use
+// the accessors of Register instead.  This remains for compatibility.
 type Object interface {
 	Type() string
 }
 
 // IntegerObject is an object holding an integer-value.
+//
+// Deprecated: use Register.GetInt and Register.SetInt instead.
 type IntegerObject struct {
 	Value int
 }
@@ -21,6 +35,8 @@ type IntegerObject struct {
 func (i *IntegerObject) Type() string { return "int" }
 
 // StringObject is an object holding a string-value.
+//
+// Deprecated: use Register.GetString and Register.SetString instead.
 type StringObject struct {
 	Value string
 }
@@ -28,59 +44,87 @@ type StringObject struct {
 // Type returns `string` for StringObjects.
 func (i *StringObject) Type() string { return "string" }
 
-// Register holds the contents of a single register, as an object.
+// Register holds the contents of a single register, which is either an
+// integer or a string.
 //
-// This means it can hold either an IntegerObject, or a StringObject.
+// The contents are stored directly, tagged with their kind, rather than
+// behind an interface, so that storing an integer never allocates.  The
+// zero value is a register holding the integer zero.
 type Register struct {
-	o Object
+	kind kind
+	i    uint64
+	s    string
 }
 
 // NewRegister is the constructor for a register.
 func NewRegister() *Register {
-	r := &Register{}
-	r.SetInt(0)
-	return (r)
+	return &Register{}
 }
 
 // GetInt retrieves the integer content of the given register.
 // If the register does not contain an integer that is a fatal error.
+//
+// Values which don't fit in an int, such as those held in 64-bit words,
+// are truncated - GetWord returns them unchanged.
 func (r *Register) GetInt() (int, error) {
-	switch arg := r.o.(type) {
-	case *IntegerObject:
-		return arg.Value, nil
+	if r.kind == intKind {
+		return int(r.i), nil
 	}
-	return 0, fmt.Errorf("attempting to call GetInt on a register holding a non-integer value: %v", r.o)
+	return 0, fmt.Errorf("attempting to call GetInt on a register holding a non-integer value: %q", r.s)
 }
 
 // SetInt stores the given integer in the register.
-// Note that a register may only contain integers in the range 0x0000-0xffff
+// Note that the value is clamped to the range 0x0000-0xffff, the range of
+// a 16-bit word, wider values may be stored via SetWord.
 func (r *Register) SetInt(v int) {
 	if v <= 0 {
-		r.o = &IntegerObject{Value: 0}
+		v = 0
 	} else if v >= 0xffff {
-		r.o = &IntegerObject{Value: 0xffff}
-	} else {
-		r.o = &IntegerObject{Value: v}
+		v = 0xffff
 	}
+	r.kind = intKind
+	r.i = uint64(v)
+	r.s = ""
+}
+
+// GetWord retrieves the integer content of the given register, as an
+// unsigned value.
+// If the register does not contain an integer that is a fatal error.
+func (r *Register) GetWord() (uint64, error) {
+	if r.kind == intKind {
+		return r.i, nil
+	}
+	return 0, fmt.Errorf("attempting to call GetWord on a register holding a non-integer value: %q", r.s)
+}
+
+// SetWord stores the given integer in the register, without clamping it.
+// Note that the CPU expects the value to fit within its word size.
+func (r *Register) SetWord(v uint64) {
+	r.kind = intKind
+	r.i = v
+	r.s = ""
 }
 
 // GetString retrieves the string content of the given register.
 // If the register does not contain a string that is a fatal error.
 func (r *Register) GetString() (string, error) {
-	switch arg := r.o.(type) {
-	case *StringObject:
-		return arg.Value, nil
+	if r.kind == stringKind {
+		return r.s, nil
 	}
-
-	return "", fmt.Errorf("attempting to call GetString on a register holding a non-string value: %v", r.o)
+	return "", fmt.Errorf("attempting to call GetString on a register holding a non-string value: %d", r.i)
 }
 
 // SetString stores the supplied string in the register.
 func (r *Register) SetString(v string) {
-	r.o = &StringObject{Value: v}
+	r.kind = stringKind
+	r.i = 0
+	r.s = v
 }
 
 // Type returns the type of a registers contents `int` vs. `string`.
 func (r *Register) Type() string {
-	return (r.o.Type())
+	if r.kind == stringKind {
+		return "string"
+	}
+	return "int"
 }
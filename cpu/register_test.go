@@ -104,3 +104,52 @@ func TestIntBounds(t *testing.T) {
 		}
 	}
 }
+
+// Test a register may hold a word, without clamping.
+func TestRegisterWord(t *testing.T) {
+	r := NewRegister()
+	r.SetWord(0xFFFFFFFFFFFFFFFF)
+	val, err := r.GetWord()
+	if err != nil || val != 0xFFFFFFFFFFFFFFFF {
+		t.Errorf("register contains the wrong value: %X %v", val, err)
+	}
+
+	r.SetString("Steve")
+	_, err = r.GetWord()
+	if err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
+
+// Test a register may change type
+func TestRegisterChangeType(t *testing.T) {
+	var r Register
+	if r.Type() != "int" {
+		t.Errorf("zero register is not an int")
+	}
+
+	r.SetString("Steve")
+	r.SetInt(3)
+	val, err := r.GetInt()
+	if r.Type() != "int" || err != nil || val != 3 {
+		t.Errorf("register contains the wrong value: %v %d %v", r.Type(), val, err)
+	}
+
+	r.SetString("Kemp")
+	str, err := r.GetString()
+	if r.Type() != "string" || err != nil || str != "Kemp" {
+		t.Errorf("register contains the wrong value: %v %s %v", r.Type(), str, err)
+	}
+}
+
+// Test that integers may be stored without allocating
+func TestRegisterAllocs(t *testing.T) {
+	r := NewRegister()
+	allocs := testing.AllocsPerRun(100, func() {
+		r.SetInt(42)
+		r.GetInt()
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
@@ -0,0 +1,182 @@
+// This file contains the recording, and replaying, of the inputs which
+// make the execution of a program nondeterministic: random numbers, and
+// the lines read from STDIN.
+//
+// When recording each input is written to a log, as a line of JSON:
+//
+//     {"kind":"random","int":4660}
+//     {"kind":"stdin","string":"Steve\n"}
+//
+// When replaying the inputs are read from the log, rather than from their
+// usual source, so the program behaves exactly as it did when recorded.
+// If the program requests an input of a different kind, or more inputs
+// than were recorded, then its execution has diverged from the recording,
+// which is reported via a *DivergenceError.
+
+package cpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// RandomInput is the kind of input generated by `random`.
+	RandomInput = "random"
+
+	// StdinInput is the kind of input read from STDIN.
+	StdinInput = "stdin"
+)
+
+// Event records a single input to the program.
+type Event struct {
+	// Kind is the kind of the input, for example StdinInput.
+	Kind string `json:"kind"`
+
+	// Int holds an integer input, such as a random number.
+	Int int `json:"int,omitempty"`
+
+	// String holds a string input, such as a line read from STDIN.
+	String string `json:"string,omitempty"`
+
+	// Error holds the message of the error, if any, which resulted.
+	Error string `json:"error,omitempty"`
+}
+
+// DivergenceError is the error returned when a program being replayed
+// requests an input which doesn't match the recording.
+type DivergenceError struct {
+	// Index is the number of inputs which had been replayed.
+	Index int
+
+	// Kind is the kind of input which was requested.
+	Kind string
+
+	// Recorded is the kind of input which was recorded, which is empty
+	// if the recording was exhausted.
+	Recorded string
+}
+
+// Error returns a description of the divergence.
+func (e *DivergenceError) Error() string {
+	if e.Recorded == "" {
+		return fmt.Sprintf("replay diverged at input %d: %s input requested, but the recording is exhausted", e.Index, e.Kind)
+	}
+	return fmt.Sprintf("replay diverged at input %d: %s input requested, but %s input was recorded", e.Index, e.Kind, e.Recorded)
+}
+
+// Record logs each input the program receives to the given writer.
+func (c *CPU) Record(w io.Writer) {
+	c.recording = json.NewEncoder(w)
+}
+
+// Replay reads the inputs logged by an earlier recording, which are
+// then given to the program instead of reading them from their usual
+// source.
+func (c *CPU) Replay(r io.Reader) error {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var ev Event
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			return fmt.Errorf("invalid recording: input %d - %s", len(events), err.Error())
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	c.replaying = true
+	c.replay = events
+	return nil
+}
+
+// ReplayRemaining returns the number of recorded inputs which haven't
+// yet been replayed.
+//
+// If this isn't zero once the program has exited then its execution
+// diverged from the recording.
+func (c *CPU) ReplayRemaining() int {
+	return len(c.replay)
+}
+
+// input returns the next input of the given kind, which is fetched by
+// the given function, unless we're replaying.
+func (c *CPU) input(kind string, fetch func() Event) (Event, error) {
+	if c.replaying {
+		if len(c.replay) == 0 {
+			return Event{}, &DivergenceError{Index: c.replayed, Kind: kind}
+		}
+		ev := c.replay[0]
+		if ev.Kind != kind {
+			return Event{}, &DivergenceError{Index: c.replayed, Kind: kind, Recorded: ev.Kind}
+		}
+		c.replay = c.replay[1:]
+		c.replayed++
+		return ev, nil
+	}
+
+	ev := fetch()
+	ev.Kind = kind
+	if c.recording != nil {
+		err := c.recording.Encode(ev)
+		if err != nil {
+			return Event{}, fmt.Errorf("failed to record input: %s", err.Error())
+		}
+	}
+	return ev, nil
+}
+
+// readLine reads a line of text from STDIN, including the trailing
+// newline.
+func (c *CPU) readLine() (string, error) {
+
+	// The number of bytes we may read, if limited.
+	max := -1
+	if c.limits.Input > 0 {
+		max = c.limits.Input - c.read
+	}
+
+	ev, err := c.input(StdinInput, func() Event {
+		var line []byte
+		for max < 0 || len(line) < max {
+			b, err := c.STDIN.ReadByte()
+			if err != nil {
+				return Event{String: string(line), Error: err.Error()}
+			}
+			line = append(line, b)
+			if b == '\n' {
+				break
+			}
+		}
+		return Event{String: string(line)}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.read += len(ev.String)
+	if ev.Error != "" {
+		if ev.Error == io.EOF.Error() {
+			return "", io.EOF
+		}
+		return "", errors.New(ev.Error)
+	}
+
+	// We stopped reading before the end of the line.
+	if !strings.HasSuffix(ev.String, "\n") {
+		return "", &LimitError{Limit: InputLimit, Max: c.limits.Input}
+	}
+	return ev.String, nil
+}
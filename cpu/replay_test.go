@@ -0,0 +1,150 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// replayProgram reads a line of input, then prints it along with a
+// random number.
+var replayProgram = []byte{
+	// 0000: int 0x0001
+	byte(opcode.TRAP_OP), 0x01, 0x00,
+	// 0003: print_str #0
+	byte(opcode.STRING_PRINT), 0x00,
+	// 0005: random #1
+	byte(opcode.INT_RANDOM), 0x01,
+	// 0007: print_int #1
+	byte(opcode.INT_PRINT), 0x01,
+	// 0009: exit
+	byte(opcode.EXIT),
+}
+
+// execute runs the given program with the given input, returning the
+// output.
+func execute(t *testing.T, c *CPU, program []byte, input string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	c.STDIN = bufio.NewReader(strings.NewReader(input))
+	c.STDOUT = bufio.NewWriter(&out)
+	c.LoadBytes(program)
+
+	err := c.Run()
+	return out.String(), err
+}
+
+// TestRecordReplay tests that a replayed program receives exactly the
+// inputs which were recorded.
+func TestRecordReplay(t *testing.T) {
+	var log bytes.Buffer
+
+	c := NewCPU()
+	c.Record(&log)
+	recorded, err := execute(t, c, replayProgram, "Steve\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"kind":"stdin","string":"Steve\n"}` || !strings.HasPrefix(lines[1], `{"kind":"random"`) {
+		t.Fatalf("unexpected recording:\n%s", log.String())
+	}
+
+	// Replaying ignores STDIN entirely.
+	c = NewCPU()
+	err = c.Replay(strings.NewReader(log.String()))
+	if err != nil {
+		t.Fatalf("failed to load recording: %s", err)
+	}
+	replayed, err := execute(t, c, replayProgram, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if replayed != recorded {
+		t.Fatalf("expected %q, got %q", recorded, replayed)
+	}
+	if c.ReplayRemaining() != 0 {
+		t.Fatalf("expected every input to be replayed, %d remain", c.ReplayRemaining())
+	}
+}
+
+// TestReplayErrors tests that errors reading input are replayed.
+func TestReplayErrors(t *testing.T) {
+	var log bytes.Buffer
+
+	c := NewCPU()
+	c.Record(&log)
+	_, err := execute(t, c, replayProgram, "partial")
+	if err == nil || err.Error() != "EOF" {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	c = NewCPU()
+	err = c.Replay(&log)
+	if err != nil {
+		t.Fatalf("failed to load recording: %s", err)
+	}
+	_, err = execute(t, c, replayProgram, "ignored\n")
+	if err == nil || err.Error() != "EOF" {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+// TestDivergence tests that a replay which doesn't match the program
+// is reported.
+func TestDivergence(t *testing.T) {
+
+	tests := map[string]string{
+		``:                                "replay diverged at input 0: stdin input requested, but the recording is exhausted",
+		`{"kind":"random","int":3}`:       "replay diverged at input 0: stdin input requested, but random input was recorded",
+		`{"kind":"stdin","string":"x\n"}`: "replay diverged at input 1: random input requested, but the recording is exhausted",
+	}
+
+	for recording, expected := range tests {
+		c := NewCPU()
+		err := c.Replay(strings.NewReader(recording))
+		if err != nil {
+			t.Fatalf("failed to load recording: %s", err)
+		}
+
+		_, err = execute(t, c, replayProgram, "")
+		fault, ok := err.(*Fault)
+		if !ok {
+			t.Fatalf("expected a fault, got %v", err)
+		}
+		if _, ok := fault.Err.(*DivergenceError); !ok || err.Error() != expected {
+			t.Fatalf("expected '%s', got '%s'", expected, err)
+		}
+	}
+
+	// Inputs which were never used are reported.
+	c := NewCPU()
+	err := c.Replay(strings.NewReader(`{"kind":"stdin","string":"x\n"}
+{"kind":"random","int":3}
+{"kind":"random","int":4}
+`))
+	if err != nil {
+		t.Fatalf("failed to load recording: %s", err)
+	}
+	out, err := execute(t, c, replayProgram, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out != "x\n03" || c.ReplayRemaining() != 1 {
+		t.Fatalf("unexpected output %q, %d remaining", out, c.ReplayRemaining())
+	}
+}
+
+// TestBrokenRecording tests that an invalid recording is rejected.
+func TestBrokenRecording(t *testing.T) {
+	c := NewCPU()
+	err := c.Replay(strings.NewReader("{\"kind\":\"random\"}\n{\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid recording: input 1") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// This file contains the snapshots, which record the complete state of
+// the machine so that a program may be stopped and later resumed -
+// possibly within a different process.
+//
+// Snapshots are stored as JSON:
+//
+//     {
+//       "version": 2,
+//       "word_size": 16,
+//       "signed": false,
+//       "saturating": false,
+//       "random": { "seed": 42, "drawn": 3 },
+//       "registers": [ { "type": "int", "int": 3 }, .. ],
+//       "z": false,
+//       "c": false,
+//       "o": false,
+//       "s": false,
+//       "ip": 16,
+//       "halted": false,
+//       "stack": [ 7 ],
+//       "memory": "..."
+//     }
+//
+// The contents of RAM are stored as a base64-encoded string.  The modes
+// of the machine are restored along with its state.  The random numbers
+// are recorded as the seed of their source, and the count of numbers
+// drawn from it, which is only possible if the source was created via
+// SetSeed - otherwise "random" is omitted, and the current source kept.
+//
+// The counts used to enforce the instruction budget, and resource limits,
+// are not part of a snapshot, and begin afresh when it is restored.
+
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// snapshotVersion is the version of the snapshot format.
+const snapshotVersion = 2
+
+// registerState records the contents of a single register.
+type registerState struct {
+	// Type is the type of the register's contents, "int" or "string".
+	Type string `json:"type"`
+
+	// Int holds the contents of an integer register.
+	Int uint64 `json:"int,omitempty"`
+
+	// String holds the contents of a string register.
+	String string `json:"string,omitempty"`
+}
+
+// randomState records the state of a seeded source of random numbers.
+type randomState struct {
+	// Seed is the seed the source was created with.
+	Seed int64 `json:"seed"`
+
+	// Drawn is the count of numbers drawn from the source.
+	Drawn uint64 `json:"drawn"`
+}
+
+// snapshot records the complete state of the machine.
+type snapshot struct {
+	Version    int             `json:"version"`
+	WordSize   int             `json:"word_size"`
+	Signed     bool            `json:"signed"`
+	Saturating bool            `json:"saturating"`
+	Random     *randomState    `json:"random,omitempty"`
+	Registers  []registerState `json:"registers"`
+	Z          bool            `json:"z"`
+	C          bool            `json:"c"`
+	O          bool            `json:"o"`
+	S          bool            `json:"s"`
+	IP         int             `json:"ip"`
+	Halted     bool            `json:"halted"`
+	Stack      []uint64        `json:"stack"`
+	Memory     []byte          `json:"memory"`
+}
+
+// Snapshot returns the complete state of the machine: the contents and
+// type of each register, the flags, the instruction-pointer, the stack,
+// and RAM.
+//
+// The state may be restored, by a later call to Restore, to resume the
+// execution of the program.
+func (c *CPU) Snapshot() ([]byte, error) {
+	s := snapshot{
+		Version:    snapshotVersion,
+		WordSize:   int(c.width),
+		Signed:     c.signed,
+		Saturating: c.saturating,
+		Z:          c.flags.z,
+		C:          c.flags.c,
+		O:          c.flags.o,
+		S:          c.flags.s,
+		IP:         c.ip,
+		Halted:     c.halted,
+		Stack:      append([]uint64{}, c.stack.entries...),
+		Memory:     c.mem[:],
+	}
+	if c.seeded != nil {
+		s.Random = &randomState{Seed: c.seeded.seed, Drawn: c.seeded.drawn}
+	}
+
+	for _, reg := range c.regs {
+		state := registerState{Type: reg.Type()}
+		switch state.Type {
+		case "int":
+			state.Int, _ = reg.GetWord()
+		case "string":
+			state.String, _ = reg.GetString()
+		}
+		s.Registers = append(s.Registers, state)
+	}
+
+	return json.Marshal(s)
+}
+
+// Restore replaces the state of the machine with that recorded by an
+// earlier call to Snapshot.
+func (c *CPU) Restore(data []byte) error {
+	var s snapshot
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %s", err.Error())
+	}
+
+	// Validate everything before changing anything.
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("invalid snapshot: unsupported version %d, expected %d", s.Version, snapshotVersion)
+	}
+	if s.WordSize != 16 && s.WordSize != 32 && s.WordSize != 64 {
+		return fmt.Errorf("invalid snapshot: unsupported word size %d", s.WordSize)
+	}
+	mask := ^uint64(0) >> uint(64-s.WordSize)
+	if len(s.Registers) != len(c.regs) {
+		return fmt.Errorf("invalid snapshot: expected %d registers, got %d", len(c.regs), len(s.Registers))
+	}
+	for i, reg := range s.Registers {
+		if reg.Type != "int" && reg.Type != "string" {
+			return fmt.Errorf("invalid snapshot: register %d has unknown type '%s'", i, reg.Type)
+		}
+		if reg.Type == "int" && reg.Int > mask {
+			return fmt.Errorf("invalid snapshot: register %d value %d does not fit in a %d-bit word", i, reg.Int, s.WordSize)
+		}
+	}
+	for i, val := range s.Stack {
+		if val > mask {
+			return fmt.Errorf("invalid snapshot: stack entry %d value %d does not fit in a %d-bit word", i, val, s.WordSize)
+		}
+	}
+	if len(s.Memory) != len(c.mem) {
+		return fmt.Errorf("invalid snapshot: expected %d bytes of RAM, got %d", len(c.mem), len(s.Memory))
+	}
+	if s.IP < 0 || s.IP >= len(c.mem) {
+		return fmt.Errorf("invalid snapshot: instruction-pointer %04X is outside RAM", s.IP)
+	}
+
+	c.Reset()
+	c.width = uint(s.WordSize)
+	c.signed = s.Signed
+	c.saturating = s.Saturating
+	if s.Random != nil {
+		c.SetRandomSource(newSeededSource(s.Random.Seed, s.Random.Drawn))
+	}
+	for i, reg := range s.Registers {
+		if reg.Type == "string" {
+			c.regs[i].SetString(reg.String)
+		} else {
+			c.regs[i].SetWord(reg.Int)
+		}
+	}
+	c.flags = Flags{z: s.Z, c: s.C, o: s.O, s: s.S}
+	c.ip = s.IP
+	c.halted = s.Halted
+	c.stack.entries = append([]uint64{}, s.Stack...)
+	copy(c.mem[:], s.Memory)
+	return nil
+}
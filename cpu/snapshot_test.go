@@ -0,0 +1,173 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// TestSnapshot tests that a program may be stopped, and resumed in a
+// different CPU, without changing its behaviour.
+func TestSnapshot(t *testing.T) {
+	program := []byte{
+		// 0000: store #1, "x"
+		byte(opcode.STRING_STORE), 0x01, 0x01, 0x00, 'x',
+		// 0005: store #2, 0x0005
+		byte(opcode.INT_STORE), 0x02, 0x05, 0x00,
+		// 0009: call 0x000F
+		byte(opcode.STACK_CALL), 0x0F, 0x00,
+		// 000C: jmp 0x0009
+		byte(opcode.JUMP_TO), 0x09, 0x00,
+		// 000F: print_str #1
+		byte(opcode.STRING_PRINT), 0x01,
+		// 0011: dec #2
+		byte(opcode.DEC_OP), 0x02,
+		// 0013: jmpz 0x0017
+		byte(opcode.JUMP_Z), 0x17, 0x00,
+		// 0016: ret
+		byte(opcode.STACK_RET),
+		// 0017: exit
+		byte(opcode.EXIT),
+	}
+
+	// Run the program from start to finish.
+	var expected bytes.Buffer
+	c := NewCPU()
+	c.STDOUT = bufio.NewWriter(&expected)
+	c.LoadBytes(program)
+	err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Now stop it midway through a subroutine.
+	var out bytes.Buffer
+	c = NewCPU()
+	c.STDOUT = bufio.NewWriter(&out)
+	c.SetMaxSteps(9)
+	c.LoadBytes(program)
+	err = c.Run()
+	if err == nil || err.(*Fault).Err != ErrBudgetExhausted {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+
+	// Resume it in another CPU.
+	resumed := NewCPU()
+	resumed.STDOUT = bufio.NewWriter(&out)
+	err = resumed.Restore(data)
+	if err != nil {
+		t.Fatalf("failed to restore: %s", err)
+	}
+	if resumed.IP() != c.IP() || len(resumed.StackEntries()) != 1 {
+		t.Fatalf("unexpected state after restoring: %04X %v", resumed.IP(), resumed.StackEntries())
+	}
+	str, err := resumed.Register(1).GetString()
+	if err != nil || str != "x" {
+		t.Fatalf("register #1 wasn't restored: %q %v", str, err)
+	}
+
+	err = resumed.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != expected.String() {
+		t.Fatalf("expected %q, got %q", expected.String(), out.String())
+	}
+
+	// Restoring a program which has exited does nothing.
+	data, err = resumed.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+	err = c.Restore(data)
+	if err != nil {
+		t.Fatalf("failed to restore: %s", err)
+	}
+	if !c.Halted() {
+		t.Fatalf("expected the program to have exited")
+	}
+}
+
+// TestBrokenSnapshots tests that invalid snapshots are rejected.
+func TestBrokenSnapshots(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes([]byte{byte(opcode.EXIT)})
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+	valid := string(data)
+
+	tests := map[string]string{
+		"{":                           "invalid snapshot: unexpected end of JSON input",
+		`{"version":3}`:               "unsupported version 3",
+		`{"version":2,"word_size":8}`: "unsupported word size 8",
+		`{"version":2,"word_size":16,"registers":[]}`:                                                           "expected 15 registers, got 0",
+		`{"version":2,"word_size":16,"registers":[{"type":"float"},{},{},{},{},{},{},{},{},{},{},{},{},{},{}]}`: "register 0 has unknown type 'float'",
+		strings.Replace(valid, `{"type":"int"}`, `{"type":"int","int":65536}`, 1):                               "register 0 value 65536 does not fit in a 16-bit word",
+		strings.Replace(valid, `"stack":[]`, `"stack":[65536]`, 1):                                              "stack entry 0 value 65536 does not fit in a 16-bit word",
+		strings.Replace(valid, `"memory":"`, `"memory":"AAAA`, 1):                                               "bytes of RAM",
+		strings.Replace(valid, `"ip":0`, `"ip":65535`, 1):                                                       "instruction-pointer FFFF is outside RAM",
+	}
+
+	for input, expected := range tests {
+		err := c.Restore([]byte(input))
+		if err == nil {
+			t.Fatalf("expected an error restoring %s", input)
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected '%s', got '%s'", expected, err)
+		}
+	}
+}
+
+// TestSnapshotModes tests that the modes of the machine, and the state
+// of its random numbers, are restored from a snapshot.
+func TestSnapshotModes(t *testing.T) {
+	c := NewCPU()
+	c.SetWordSize(32)
+	c.SetSigned(true)
+	c.SetSaturating(true)
+	c.SetSeed(7)
+	roll(t, c, 0, 0xFFFE)
+
+	data, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+	expected := roll(t, c, 0, 0xFFFE)
+
+	resumed := NewCPU()
+	err = resumed.Restore(data)
+	if err != nil {
+		t.Fatalf("failed to restore: %s", err)
+	}
+	if resumed.WordSize() != 32 || !resumed.Signed() || !resumed.saturating {
+		t.Fatalf("the modes weren't restored")
+	}
+	got := roll(t, resumed, 0, 0xFFFE)
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+
+	// A source we can't record is left alone.
+	c.SetRandomSource(rand.NewSource(7))
+	data, err = c.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot: %s", err)
+	}
+	if strings.Contains(string(data), `"random"`) {
+		t.Fatalf("unexpected random state: %s", data)
+	}
+}
@@ -9,8 +9,9 @@ import "errors"
 // Stack holds return-addresses when the `call` operation is being
 // completed.  It can also be used for storing ints.
 type Stack struct {
-	// The entries on our stack
-	entries []int
+	// The entries on our stack, which are words so that they may hold
+	// the contents of any register.
+	entries []uint64
 }
 
 //
@@ -34,11 +35,25 @@ func (s *Stack) Size() int {
 
 // Push adds a value to the stack.
 func (s *Stack) Push(value int) {
-	s.entries = append(s.entries, value)
+	s.PushWord(uint64(value))
 }
 
 // Pop removes a value from the stack.
+//
+// Values which don't fit in an int are truncated, PopWord returns them
+// unchanged.
 func (s *Stack) Pop() (int, error) {
+	val, err := s.PopWord()
+	return int(val), err
+}
+
+// PushWord adds a word to the stack.
+func (s *Stack) PushWord(value uint64) {
+	s.entries = append(s.entries, value)
+}
+
+// PopWord removes a word from the stack.
+func (s *Stack) PopWord() (uint64, error) {
 	if s.Empty() {
 		return 0, errors.New("Pop from an empty stack")
 	}
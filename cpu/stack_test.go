@@ -56,7 +56,6 @@ func TestEmptyStack(t *testing.T) {
 	}
 }
 
-
 // Test issue #12 - stack is FIFO, not LIFO
 func TestIssue12(t *testing.T) {
 
@@ -44,7 +44,7 @@ func StrLenTrap(c *CPU, num int) error {
 	if err != nil {
 		return err
 	}
-	c.regs[0].SetInt(len(str))
+	c.setInt(0, len(str))
 	return nil
 }
 
@@ -56,12 +56,11 @@ func StrLenTrap(c *CPU, num int) error {
 //   Sets register 0 with the user-provided string
 //
 func ReadStringTrap(c *CPU, num int) error {
-	text, err := c.STDIN.ReadString('\n')
+	text, err := c.readLine()
 	if err != nil {
 		return err
 	}
-	c.regs[0].SetString(text)
-	return nil
+	return c.setString(0, text)
 }
 
 // RemoveNewLineTrap removes any trailing newline from the string in #0
@@ -76,8 +75,7 @@ func RemoveNewLineTrap(c *CPU, num int) error {
 	if err != nil {
 		return err
 	}
-	c.regs[0].SetString(strings.TrimSpace(str))
-	return nil
+	return c.setString(0, strings.TrimSpace(str))
 }
 
 // init configures our registered traps.
@@ -13,9 +13,13 @@ import (
 // Global functions
 //
 
+// debugging is set if `$DEBUG` was set when we started, to avoid testing
+// the environment as each instruction is executed.
+var debugging = os.Getenv("DEBUG") != ""
+
 // debugPrintf outputs some debugging details when `$DEBUG=1`.
 func debugPrintf(format string, args ...interface{}) {
-	if os.Getenv("DEBUG") == "" {
+	if !debugging {
 		return
 	}
 	fmt.Printf(format, args...)
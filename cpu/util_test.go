@@ -1,7 +1,6 @@
 package cpu
 
 import (
-	"os"
 	"testing"
 )
 
@@ -39,6 +38,7 @@ func TestSplit(t *testing.T) {
 // This is not a real test, just to bump our coverage.
 func TestDebug(t *testing.T) {
 	debugPrintf("")
-	os.Setenv("DEBUG", "1")
+	debugging = true
 	debugPrintf("")
+	debugging = false
 }
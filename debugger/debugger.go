@@ -0,0 +1,615 @@
+// Package debugger contains an interactive debugger for our virtual
+// machine.
+//
+// The debugger reads commands, one per line, and executes them against
+// a CPU which has had a program loaded into it.  It allows a program to
+// be stepped through one instruction at a time, or run until it reaches
+// a breakpoint, and shows the state of the machine as it does so.
+//
+// If debug information is available the debugger shows the source line
+// which generated each instruction, and allows labels to be used in place
+// of addresses.
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/debuginfo"
+	"github.com/skx/go.vm/disassembler"
+	"github.com/skx/go.vm/opcode"
+)
+
+// Debugger holds the state of our debugger.
+type Debugger struct {
+	// cpu is the machine we're debugging.
+	cpu *cpu.CPU
+
+	// info holds our debug information, which may be nil.
+	info *debuginfo.Info
+
+	// symbols maps the names of labels to their addresses.
+	symbols map[string]int
+
+	// names maps addresses to the names of labels.
+	names map[int]string
+
+	// breakpoints holds the addresses we stop at.
+	breakpoints map[int]bool
+
+	// in is where we read commands from.
+	in *bufio.Reader
+
+	// out is where we write our output.
+	out io.Writer
+
+	// last is the most recent command, which is repeated if an empty
+	// line is entered.
+	last string
+}
+
+// command describes a single command the debugger understands.
+type command struct {
+	// names holds the name of the command, and any aliases.
+	names []string
+
+	// usage describes the arguments of the command.
+	usage string
+
+	// help describes what the command does.
+	help string
+
+	// fn implements the command, returning true if the debugger
+	// should exit.
+	fn func(d *Debugger, args []string) bool
+}
+
+// commands holds all the commands we understand.
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"help", "h", "?"}, "", "Show this help.", (*Debugger).help},
+		{[]string{"break", "b"}, "ADDR|LABEL", "Set a breakpoint.", (*Debugger).setBreakpoint},
+		{[]string{"delete", "d"}, "[ADDR|LABEL]", "Remove a breakpoint, or all of them.", (*Debugger).deleteBreakpoint},
+		{[]string{"breakpoints", "bl"}, "", "List the breakpoints.", (*Debugger).listBreakpoints},
+		{[]string{"step", "s"}, "[COUNT]", "Execute a single instruction, or COUNT of them.", (*Debugger).step},
+		{[]string{"next", "n"}, "", "Execute a single instruction, stepping over calls.", (*Debugger).next},
+		{[]string{"continue", "c"}, "", "Run until a breakpoint, or the program exits.", (*Debugger).cont},
+		{[]string{"finish", "f"}, "", "Run until the current subroutine returns.", (*Debugger).finish},
+		{[]string{"where", "w"}, "", "Show the next instruction, and its source.", (*Debugger).where},
+		{[]string{"registers", "regs", "r"}, "", "Show the registers and flags.", (*Debugger).registers},
+		{[]string{"stack"}, "", "Show the stack.", (*Debugger).stack},
+		{[]string{"x"}, "ADDR|LABEL [LENGTH]", "Show a hexdump of memory.", (*Debugger).examine},
+		{[]string{"set"}, "#REG|FLAG|ip VALUE", "Change a register, a flag (z, c, o, or s), or the instruction-pointer.", (*Debugger).set},
+		{[]string{"poke"}, "ADDR|LABEL BYTE..", "Change the contents of memory.", (*Debugger).poke},
+		{[]string{"quit", "q"}, "", "Exit the debugger.", (*Debugger).quit},
+	}
+}
+
+// New creates a debugger for the given CPU, which must have a program
+// loaded.  The debug information may be nil.
+func New(c *cpu.CPU, info *debuginfo.Info, in *bufio.Reader, out io.Writer) *Debugger {
+	d := &Debugger{
+		cpu:         c,
+		info:        info,
+		symbols:     make(map[string]int),
+		names:       make(map[int]string),
+		breakpoints: make(map[int]bool),
+		in:          in,
+		out:         out,
+	}
+	if info != nil {
+		for _, l := range info.Labels {
+			d.AddSymbol(l.Name, l.Address)
+		}
+	}
+	return d
+}
+
+// AddSymbol records the address of a label, which is used when no
+// debug information is available.
+func (d *Debugger) AddSymbol(name string, address int) {
+	d.symbols[name] = address
+	if _, ok := d.names[address]; !ok {
+		d.names[address] = name
+	}
+}
+
+// Run reads, and executes, commands until told to quit or there is no
+// more input.
+func (d *Debugger) Run() error {
+	d.where(nil)
+
+	for {
+		fmt.Fprintf(d.out, "(debug) ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				fmt.Fprintln(d.out)
+				return nil
+			}
+			return err
+		}
+
+		if d.Execute(line) {
+			return nil
+		}
+	}
+}
+
+// Execute executes a single command, returning true if the debugger
+// should exit.
+func (d *Debugger) Execute(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = d.last
+	}
+	d.last = line
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+
+	for _, cmd := range commands {
+		for _, name := range cmd.names {
+			if name == fields[0] {
+				quit := cmd.fn(d, fields[1:])
+				d.cpu.STDOUT.Flush()
+				return quit
+			}
+		}
+	}
+
+	fmt.Fprintf(d.out, "unknown command '%s', try 'help'\n", fields[0])
+	return false
+}
+
+// help shows the available commands.
+func (d *Debugger) help(args []string) bool {
+	for _, cmd := range commands {
+		name := strings.Join(cmd.names, ", ")
+		if cmd.usage != "" {
+			name += " " + cmd.usage
+		}
+		fmt.Fprintf(d.out, "  %-30s %s\n", name, cmd.help)
+	}
+	fmt.Fprintf(d.out, "An empty line repeats the previous command.\n")
+	return false
+}
+
+// resolve converts an argument to an address, which may be a number or
+// the name of a label.
+func (d *Debugger) resolve(arg string) (int, error) {
+	if addr, ok := d.symbols[arg]; ok {
+		return addr, nil
+	}
+	if d.info != nil {
+		if addr, ok := d.info.Address(arg); ok {
+			return addr, nil
+		}
+	}
+
+	val, err := strconv.ParseInt(arg, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is neither an address nor a label", arg)
+	}
+	if val < 0 || val >= bytecode.MemorySize {
+		return 0, fmt.Errorf("address %04X is outside RAM", val)
+	}
+	return int(val), nil
+}
+
+// describe returns a description of the given address, including the
+// label it is within, if known.
+func (d *Debugger) describe(addr int) string {
+	if name, ok := d.names[addr]; ok {
+		return fmt.Sprintf("%04X (%s)", addr, name)
+	}
+	if d.info != nil {
+		if l, ok := d.info.Enclosing(addr); ok {
+			return fmt.Sprintf("%04X (%s+%d)", addr, l.Name, addr-l.Address)
+		}
+	}
+	return fmt.Sprintf("%04X", addr)
+}
+
+// setBreakpoint sets a breakpoint.
+func (d *Debugger) setBreakpoint(args []string) bool {
+	if len(args) != 1 {
+		fmt.Fprintf(d.out, "usage: break ADDR|LABEL\n")
+		return false
+	}
+	addr, err := d.resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+	d.breakpoints[addr] = true
+	fmt.Fprintf(d.out, "breakpoint set at %s\n", d.describe(addr))
+	return false
+}
+
+// deleteBreakpoint removes a breakpoint, or all of them.
+func (d *Debugger) deleteBreakpoint(args []string) bool {
+	if len(args) == 0 {
+		d.breakpoints = make(map[int]bool)
+		fmt.Fprintf(d.out, "all breakpoints removed\n")
+		return false
+	}
+	addr, err := d.resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+	if !d.breakpoints[addr] {
+		fmt.Fprintf(d.out, "no breakpoint at %s\n", d.describe(addr))
+		return false
+	}
+	delete(d.breakpoints, addr)
+	fmt.Fprintf(d.out, "breakpoint removed from %s\n", d.describe(addr))
+	return false
+}
+
+// listBreakpoints shows the breakpoints.
+func (d *Debugger) listBreakpoints(args []string) bool {
+	var addrs []int
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	if len(addrs) == 0 {
+		fmt.Fprintf(d.out, "no breakpoints\n")
+	}
+	for _, addr := range addrs {
+		fmt.Fprintf(d.out, "  %s\n", d.describe(addr))
+	}
+	return false
+}
+
+// step executes one, or more, instructions.
+func (d *Debugger) step(args []string) bool {
+	count := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			fmt.Fprintf(d.out, "invalid count '%s'\n", args[0])
+			return false
+		}
+		count = n
+	}
+
+	for i := 0; i < count; i++ {
+		if _, ok := d.execute(); !ok {
+			return false
+		}
+		if i < count-1 && d.breakpoints[d.cpu.IP()] {
+			fmt.Fprintf(d.out, "breakpoint at %s\n", d.describe(d.cpu.IP()))
+			break
+		}
+	}
+	d.where(nil)
+	return false
+}
+
+// next executes a single instruction, but runs any subroutine it calls
+// until that returns.
+func (d *Debugger) next(args []string) bool {
+	in, err := d.instruction()
+	if err != nil || int(in.Opcode) != opcode.STACK_CALL {
+		return d.step(nil)
+	}
+
+	ret := in.Address + in.Length
+	depth := len(d.cpu.StackEntries())
+	d.run(func(op byte) bool {
+		return d.cpu.IP() == ret && len(d.cpu.StackEntries()) == depth
+	})
+	return false
+}
+
+// cont runs until a breakpoint is reached, or the program exits.
+func (d *Debugger) cont(args []string) bool {
+	d.run(func(op byte) bool { return false })
+	return false
+}
+
+// finish runs until the current subroutine returns.
+func (d *Debugger) finish(args []string) bool {
+	depth := len(d.cpu.StackEntries())
+	if depth == 0 {
+		fmt.Fprintf(d.out, "not within a subroutine\n")
+		return false
+	}
+
+	d.run(func(op byte) bool {
+		return int(op) == opcode.STACK_RET && len(d.cpu.StackEntries()) < depth
+	})
+	return false
+}
+
+// execute executes a single instruction, reporting any problem, and
+// returns the opcode executed and true if execution may continue.
+func (d *Debugger) execute() (byte, bool) {
+	res, err := d.cpu.Step()
+	if err == cpu.ErrHalted {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return res.Opcode, false
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "error: %s\n", err)
+		return res.Opcode, false
+	}
+	if res.Halted {
+		fmt.Fprintf(d.out, "the program has exited\n")
+		return res.Opcode, false
+	}
+	return res.Opcode, true
+}
+
+// run executes instructions until a breakpoint is reached, the program
+// exits, or the given function - which is passed the opcode of each
+// instruction after it has executed - returns true.
+func (d *Debugger) run(stop func(op byte) bool) {
+	for {
+		op, ok := d.execute()
+		if !ok {
+			return
+		}
+		if d.breakpoints[d.cpu.IP()] {
+			fmt.Fprintf(d.out, "breakpoint at %s\n", d.describe(d.cpu.IP()))
+			break
+		}
+		if stop(op) {
+			break
+		}
+	}
+	d.where(nil)
+}
+
+// instruction decodes the next instruction to be executed.
+func (d *Debugger) instruction() (*opcode.Instruction, error) {
+	mem, err := d.cpu.ReadMemory(0, bytecode.MemorySize)
+	if err != nil {
+		return nil, err
+	}
+	return opcode.Decode(mem, d.cpu.IP())
+}
+
+// where shows the next instruction, and the source which generated it.
+func (d *Debugger) where(args []string) bool {
+	if d.cpu.Halted() {
+		return false
+	}
+
+	ip := d.cpu.IP()
+	in, err := d.instruction()
+	if err != nil {
+		fmt.Fprintf(d.out, "%s  %s\n", d.describe(ip), err)
+	} else {
+		fmt.Fprintf(d.out, "%s  %s\n", d.describe(ip), disassembler.Format(in, d.names))
+	}
+
+	if d.info == nil {
+		return false
+	}
+	line, ok := d.info.Lookup(ip)
+	if !ok {
+		return false
+	}
+	src, err := line.Source()
+	if err != nil {
+		fmt.Fprintf(d.out, "  %s:%d\n", line.File, line.Line)
+	} else {
+		fmt.Fprintf(d.out, "  %s:%d  %s\n", line.File, line.Line, strings.TrimSpace(src))
+	}
+	return false
+}
+
+// registers shows the contents of each register, and the flags.
+func (d *Debugger) registers(args []string) bool {
+	for i := 0; i < cpu.RegisterCount; i++ {
+		reg := d.cpu.Register(i)
+		switch reg.Type() {
+		case "int":
+			val, _ := reg.GetWord()
+			num := strconv.FormatUint(val, 10)
+			if d.cpu.Signed() {
+				shift := uint(64 - d.cpu.WordSize())
+				num = strconv.FormatInt(int64(val<<shift)>>shift, 10)
+			}
+			fmt.Fprintf(d.out, "  #%-2d int     %s (0x%0*X)\n", i, num, d.cpu.WordSize()/4, val)
+		case "string":
+			val, _ := reg.GetString()
+			fmt.Fprintf(d.out, "  #%-2d string  %q\n", i, val)
+		}
+	}
+	fmt.Fprintf(d.out, "  ip      %s\n", d.describe(d.cpu.IP()))
+	fmt.Fprintf(d.out, "  z       %t\n", d.cpu.ZeroFlag())
+	fmt.Fprintf(d.out, "  c       %t\n", d.cpu.CarryFlag())
+	fmt.Fprintf(d.out, "  o       %t\n", d.cpu.OverflowFlag())
+	fmt.Fprintf(d.out, "  s       %t\n", d.cpu.SignFlag())
+	return false
+}
+
+// stack shows the contents of the stack, most recent first.
+func (d *Debugger) stack(args []string) bool {
+	entries := d.cpu.StackEntries()
+	if len(entries) == 0 {
+		fmt.Fprintf(d.out, "the stack is empty\n")
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "  %2d  %04X\n", len(entries)-1-i, entries[i])
+	}
+	return false
+}
+
+// examine shows a hexdump of memory.
+func (d *Debugger) examine(args []string) bool {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(d.out, "usage: x ADDR|LABEL [LENGTH]\n")
+		return false
+	}
+	addr, err := d.resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+	length := 64
+	if len(args) == 2 {
+		length, err = strconv.Atoi(args[1])
+		if err != nil || length < 1 {
+			fmt.Fprintf(d.out, "invalid length '%s'\n", args[1])
+			return false
+		}
+	}
+	if addr+length > bytecode.MemorySize {
+		length = bytecode.MemorySize - addr
+	}
+
+	mem, err := d.cpu.ReadMemory(addr, length)
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+
+	for off := 0; off < len(mem); off += 16 {
+		end := off + 16
+		if end > len(mem) {
+			end = len(mem)
+		}
+		hex := make([]string, 0, 16)
+		text := make([]byte, 0, 16)
+		for _, b := range mem[off:end] {
+			hex = append(hex, fmt.Sprintf("%02X", b))
+			if b >= 0x20 && b < 0x7F {
+				text = append(text, b)
+			} else {
+				text = append(text, '.')
+			}
+		}
+		fmt.Fprintf(d.out, "  %04X  %-47s  %s\n", addr+off, strings.Join(hex, " "), text)
+	}
+	return false
+}
+
+// set changes a register, a flag, or the instruction-pointer.
+func (d *Debugger) set(args []string) bool {
+	if len(args) < 2 {
+		fmt.Fprintf(d.out, "usage: set #REG|FLAG|ip VALUE\n")
+		return false
+	}
+	value := strings.Join(args[1:], " ")
+
+	flags := map[string]func(bool){
+		"z": d.cpu.SetZeroFlag,
+		"c": d.cpu.SetCarryFlag,
+		"o": d.cpu.SetOverflowFlag,
+		"s": d.cpu.SetSignFlag,
+	}
+
+	switch {
+	case flags[args[0]] != nil:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			fmt.Fprintf(d.out, "invalid flag value '%s'\n", value)
+			return false
+		}
+		flags[args[0]](v)
+
+	case args[0] == "ip":
+		addr, err := d.resolve(value)
+		if err == nil {
+			err = d.cpu.SetIP(addr)
+		}
+		if err != nil {
+			fmt.Fprintf(d.out, "%s\n", err)
+			return false
+		}
+		d.where(nil)
+
+	case strings.HasPrefix(args[0], "#"):
+		n, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+		reg := d.cpu.Register(n)
+		if err != nil || reg == nil {
+			fmt.Fprintf(d.out, "invalid register '%s'\n", args[0])
+			return false
+		}
+
+		if strings.HasPrefix(value, "\"") {
+			str, err := strconv.Unquote(value)
+			if err != nil {
+				fmt.Fprintf(d.out, "invalid string %s\n", value)
+				return false
+			}
+			reg.SetString(str)
+		} else {
+			val, err := strconv.ParseInt(value, 0, 64)
+			if err != nil {
+				fmt.Fprintf(d.out, "invalid number '%s'\n", value)
+				return false
+			}
+
+			// negative numbers are stored in two's complement
+			// form, in signed mode, and values are clamped to
+			// the range of a word.
+			mask := ^uint64(0) >> uint(64-d.cpu.WordSize())
+			switch {
+			case val < 0 && d.cpu.Signed():
+				reg.SetWord(uint64(val) & mask)
+			case val < 0:
+				reg.SetWord(0)
+			case uint64(val) > mask:
+				reg.SetWord(mask)
+			default:
+				reg.SetWord(uint64(val))
+			}
+		}
+
+	default:
+		fmt.Fprintf(d.out, "cannot set '%s'\n", args[0])
+	}
+	return false
+}
+
+// poke changes the contents of memory.
+func (d *Debugger) poke(args []string) bool {
+	if len(args) < 2 {
+		fmt.Fprintf(d.out, "usage: poke ADDR|LABEL BYTE..\n")
+		return false
+	}
+	addr, err := d.resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+
+	var data []byte
+	for _, arg := range args[1:] {
+		val, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil {
+			fmt.Fprintf(d.out, "invalid byte '%s'\n", arg)
+			return false
+		}
+		data = append(data, byte(val))
+	}
+
+	err = d.cpu.WriteMemory(addr, data)
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+	}
+	return false
+}
+
+// quit exits the debugger.
+func (d *Debugger) quit(args []string) bool {
+	return true
+}
@@ -0,0 +1,127 @@
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/lexer"
+)
+
+// session runs the debugger against the given program, with the given
+// commands, and returns the output of both.
+func session(t *testing.T, src string, commands string) (string, string) {
+	t.Helper()
+
+	c := compiler.New(lexer.New(src))
+	c.SetFilename("test.in")
+	if err := c.Compile(); err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	var stdout, out bytes.Buffer
+	vm := cpu.NewCPU()
+	vm.LoadProgram(c.Program())
+	vm.STDOUT = bufio.NewWriter(&stdout)
+
+	d := New(vm, c.DebugInfo(), bufio.NewReader(strings.NewReader(commands)), &out)
+	if err := d.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return stdout.String(), out.String()
+}
+
+const program = `
+        store #1, 3
+        call double
+        print_int #1
+:end
+        exit
+:double
+        inc #1
+        inc #1
+        ret
+`
+
+// TestBreakpoints tests breakpoints, by label and address.
+func TestBreakpoints(t *testing.T) {
+	stdout, out := session(t, program, "break double\nb 0x0007\nbl\nc\nc\nd double\nbl\nc\n")
+
+	for _, text := range []string{
+		"breakpoint set at 000A (double)",
+		"breakpoint set at 0007",
+		"  0007\n  000A (double)\n",
+		"breakpoint at 000A (double)\n000A (double)  inc #1\n  test.in:8",
+		"breakpoint at 0007\n0007  print_int #1\n  test.in:4",
+		"breakpoint removed from 000A (double)",
+		"the program has exited",
+	} {
+		if !strings.Contains(out, text) {
+			t.Errorf("expected '%s' in output:\n%s", text, out)
+		}
+	}
+	if stdout != "05" {
+		t.Errorf("unexpected program output '%s'", stdout)
+	}
+}
+
+// TestStepping tests stepping, stepping over calls, and finishing.
+func TestStepping(t *testing.T) {
+	_, out := session(t, program, "s\ns\nstack\ns\nfinish\nstack\nr\nset ip 4\nn\nr\n")
+
+	for _, text := range []string{
+		"(debug) 0004  call double\n",
+		"(debug) 000A (double)  inc #1\n",
+		"(debug)    0  0007\n",
+		"(debug) 000C (double+2)  inc #1\n",
+		"(debug) 0007  print_int #1\n",
+		"(debug) the stack is empty",
+		"#1  int     5 (0x0005)",
+		"#1  int     7 (0x0007)",
+	} {
+		if !strings.Contains(out, text) {
+			t.Errorf("expected '%s' in output:\n%s", text, out)
+		}
+	}
+}
+
+// TestEditing tests changing registers, flags, and memory.
+func TestEditing(t *testing.T) {
+	stdout, out := session(t, program, "set #1 \"hi\"\nset #2 0x10\nset z true\nr\npoke end 0x50 0x00\nx end 2\nset ip end\nc\n")
+
+	for _, text := range []string{
+		"#1  string  \"hi\"",
+		"#2  int     16 (0x0010)",
+		"z       true",
+		"  0009  50 00    ",
+		"0009 (end)  nop\n",
+		"the program has exited",
+	} {
+		if !strings.Contains(out, text) {
+			t.Errorf("expected '%s' in output:\n%s", text, out)
+		}
+	}
+	if stdout != "" {
+		t.Errorf("unexpected program output '%s'", stdout)
+	}
+}
+
+// TestErrors tests bogus commands are reported.
+func TestErrors(t *testing.T) {
+	_, out := session(t, program, "bogus\nbreak missing\nset #99 1\nx\nfinish\n")
+
+	for _, text := range []string{
+		"unknown command 'bogus'",
+		"'missing' is neither an address nor a label",
+		"invalid register '#99'",
+		"usage: x ADDR|LABEL [LENGTH]",
+		"not within a subroutine",
+	} {
+		if !strings.Contains(out, text) {
+			t.Errorf("expected '%s' in output:\n%s", text, out)
+		}
+	}
+}
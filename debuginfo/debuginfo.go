@@ -0,0 +1,173 @@
+// Package debuginfo contains the debugging information which the compiler
+// may generate alongside the bytecode it outputs.
+//
+// The debugging information maps offsets within the bytecode back to the
+// source file, and line, which produced them, and records the address of
+// each label.  This allows errors found at runtime to be reported in terms
+// of the program that was written, rather than the bytecode.
+//
+// The information is stored in a JSON "sidecar" file which lives alongside
+// the bytecode - so `hello.raw` has debug information in `hello.dbg`.
+package debuginfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Line maps a region of bytecode to the source line which produced it.
+type Line struct {
+	// Address is the offset of the first byte of the region.
+	Address int `json:"address"`
+
+	// Length is the number of bytes in the region.
+	Length int `json:"length"`
+
+	// File is the name of the source file.
+	File string `json:"file"`
+
+	// Line is the line within the source file, starting from 1.
+	Line int `json:"line"`
+}
+
+// Label records the address of a label.
+type Label struct {
+	// Name is the name of the label, without the ":" prefix.
+	Name string `json:"name"`
+
+	// Address is the offset of the label in the bytecode.
+	Address int `json:"address"`
+}
+
+// Info contains the debugging information for a single program.
+type Info struct {
+	// Lines holds the source lines, ordered by address.
+	Lines []Line `json:"lines"`
+
+	// Labels holds the labels, ordered by address.
+	Labels []Label `json:"labels"`
+
+	// sorted is true if our lines and labels are ordered.
+	sorted bool
+}
+
+// Filename returns the name of the debug-information file which should
+// accompany the given bytecode file.
+func Filename(bytecode string) string {
+	return strings.TrimSuffix(bytecode, filepath.Ext(bytecode)) + ".dbg"
+}
+
+// Load reads debugging information from the named file.
+func Load(path string) (*Info, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse debug information %s - %s", path, err.Error())
+	}
+	return info, nil
+}
+
+// Save writes the debugging information to the named file.
+func (i *Info) Save(path string) error {
+	i.sort()
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// sort ensures our lines and labels are ordered by address, which our
+// lookup functions require.
+func (i *Info) sort() {
+	if i.sorted {
+		return
+	}
+	i.sorted = true
+
+	sort.SliceStable(i.Lines, func(a, b int) bool {
+		return i.Lines[a].Address < i.Lines[b].Address
+	})
+	sort.SliceStable(i.Labels, func(a, b int) bool {
+		return i.Labels[a].Address < i.Labels[b].Address
+	})
+}
+
+// AddLine records that the given region of bytecode was produced by the
+// specified source line.
+func (i *Info) AddLine(address int, length int, file string, line int) {
+	i.Lines = append(i.Lines, Line{Address: address, Length: length, File: file, Line: line})
+	i.sorted = false
+}
+
+// AddLabel records the address of the given label.
+func (i *Info) AddLabel(name string, address int) {
+	i.Labels = append(i.Labels, Label{Name: name, Address: address})
+	i.sorted = false
+}
+
+// Lookup returns the source line which produced the byte at the given
+// address, if any.
+func (i *Info) Lookup(address int) (Line, bool) {
+	i.sort()
+
+	// Find the first region which ends after the address.
+	n := sort.Search(len(i.Lines), func(x int) bool {
+		return i.Lines[x].Address+i.Lines[x].Length > address
+	})
+
+	if n < len(i.Lines) && i.Lines[n].Address <= address {
+		return i.Lines[n], true
+	}
+	return Line{}, false
+}
+
+// Enclosing returns the label which most-closely precedes the given
+// address, which is typically the subroutine containing it.
+func (i *Info) Enclosing(address int) (Label, bool) {
+	i.sort()
+
+	// Find the first label which is after the address.
+	n := sort.Search(len(i.Labels), func(x int) bool {
+		return i.Labels[x].Address > address
+	})
+
+	if n == 0 {
+		return Label{}, false
+	}
+	return i.Labels[n-1], true
+}
+
+// Address returns the address of the named label.
+func (i *Info) Address(name string) (int, bool) {
+	for _, l := range i.Labels {
+		if l.Name == name {
+			return l.Address, true
+		}
+	}
+	return 0, false
+}
+
+// Source returns the text of the given source line, by reading the file
+// it came from.
+func (l Line) Source() (string, error) {
+	data, err := ioutil.ReadFile(l.File)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if l.Line < 1 || l.Line > len(lines) {
+		return "", fmt.Errorf("%s has no line %d", l.File, l.Line)
+	}
+	return strings.TrimRight(lines[l.Line-1], "\r"), nil
+}
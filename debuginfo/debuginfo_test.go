@@ -0,0 +1,105 @@
+package debuginfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLookup ensures addresses map to the right lines and labels.
+func TestLookup(t *testing.T) {
+	i := &Info{}
+	i.AddLine(4, 2, "test.in", 3)
+	i.AddLine(0, 4, "test.in", 1)
+	i.AddLine(8, 1, "test.in", 7)
+	i.AddLabel("end", 8)
+	i.AddLabel("start", 0)
+
+	tests := []struct {
+		address int
+		found   bool
+		line    int
+		label   string
+	}{
+		{0, true, 1, "start"},
+		{3, true, 1, "start"},
+		{4, true, 3, "start"},
+		{6, false, 0, "start"},
+		{8, true, 7, "end"},
+		{9, false, 0, "end"},
+	}
+
+	for _, test := range tests {
+		line, ok := i.Lookup(test.address)
+		if ok != test.found {
+			t.Fatalf("lookup of %d: expected found=%t", test.address, test.found)
+		}
+		if ok && line.Line != test.line {
+			t.Fatalf("lookup of %d: got line %d, expected %d", test.address, line.Line, test.line)
+		}
+		label, ok := i.Enclosing(test.address)
+		if !ok || label.Name != test.label {
+			t.Fatalf("enclosing label of %d: got %s, expected %s", test.address, label.Name, test.label)
+		}
+	}
+
+	addr, ok := i.Address("end")
+	if !ok || addr != 8 {
+		t.Fatalf("failed to find label address")
+	}
+	_, ok = i.Address("missing")
+	if ok {
+		t.Fatalf("found a missing label")
+	}
+}
+
+// TestSaveLoad ensures we can round-trip our information via a file.
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debuginfo")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "test.in")
+	err = ioutil.WriteFile(src, []byte("store #1, 3\nexit\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write source: %s", err)
+	}
+
+	i := &Info{}
+	i.AddLine(0, 4, src, 1)
+	i.AddLine(4, 1, src, 2)
+	i.AddLabel("start", 0)
+
+	path := Filename(filepath.Join(dir, "test.raw"))
+	if filepath.Base(path) != "test.dbg" {
+		t.Fatalf("unexpected filename %s", path)
+	}
+	err = i.Save(path)
+	if err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	out, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	line, ok := out.Lookup(4)
+	if !ok {
+		t.Fatalf("failed to lookup line")
+	}
+	text, err := line.Source()
+	if err != nil {
+		t.Fatalf("failed to read source: %s", err)
+	}
+	if text != "exit" {
+		t.Fatalf("wrong source line: '%s'", text)
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.dbg"))
+	if err == nil {
+		t.Fatalf("expected error loading missing file")
+	}
+}
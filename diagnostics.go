@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/debuginfo"
+)
+
+//
+// Show the diagnostics found when compiling a program, one per line.
+//
+func showDiagnostics(diagnostics compiler.Diagnostics) {
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d.String())
+	}
+}
+
+//
+// Show the location of a runtime error, using the debug information
+// to report the source line, and enclosing label, of the instruction
+// which failed.
+//
+// If there is no debug information available this does nothing.
+//
+func showFault(err error, info *debuginfo.Info) {
+	var fault *cpu.Fault
+	if info == nil || !errors.As(err, &fault) {
+		return
+	}
+
+	line, ok := info.Lookup(fault.IP)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "  at address %04X\n", fault.IP)
+		return
+	}
+
+	location := fmt.Sprintf("%s:%d", line.File, line.Line)
+	if label, found := info.Enclosing(fault.IP); found {
+		location += fmt.Sprintf(" in %s", label.Name)
+	}
+	fmt.Fprintf(os.Stderr, "  at %s (address %04X)\n", location, fault.IP)
+
+	if src, srcErr := line.Source(); srcErr == nil {
+		fmt.Fprintf(os.Stderr, "  %5d | %s\n", line.Line, src)
+	}
+}
@@ -0,0 +1,334 @@
+// Package disassembler converts bytecode back into source which our
+// compiler accepts.
+//
+// The bytecode is decoded from start to finish, one instruction at a
+// time.  Anything which can't be decoded - unknown opcodes, truncated
+// instructions, strings the lexer couldn't represent, and numbers given a
+// longer encoding than the compiler would choose - is output as `DB` data
+// instead.  The destination of each jump and call is given a label,
+// named by the program's symbol table or synthesized, where possible.
+//
+// The output is intended to be re-compiled, for the same word size, and
+// will produce bytecode which is identical to the input.
+package disassembler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/opcode"
+)
+
+// maxRegister is the highest register number the compiler accepts.
+const maxRegister = 15
+
+// mnemonics holds the source-form of each instruction.
+var mnemonics = map[int]string{
+	opcode.EXIT:             "exit",
+	opcode.INT_STORE:        "store",
+	opcode.INT_PRINT:        "print_int",
+	opcode.INT_TOSTRING:     "int2string",
+	opcode.INT_RANDOM:       "random",
+	opcode.INT_RANDOM_RANGE: "random",
+	opcode.INT_STORE32:      "store",
+	opcode.INT_STORE64:      "store",
+	opcode.JUMP_TO:          "jmp",
+	opcode.JUMP_Z:           "jmpz",
+	opcode.JUMP_NZ:          "jmpnz",
+	opcode.JUMP_C:           "jmpc",
+	opcode.JUMP_NC:          "jmpnc",
+	opcode.JUMP_O:           "jmpo",
+	opcode.JUMP_NO:          "jmpno",
+	opcode.JUMP_N:           "jmpn",
+	opcode.JUMP_NN:          "jmpnn",
+	opcode.JUMP_LT:          "jmplt",
+	opcode.JUMP_LE:          "jmple",
+	opcode.JUMP_GT:          "jmpgt",
+	opcode.JUMP_GE:          "jmpge",
+	opcode.XOR_OP:           "xor",
+	opcode.ADD_OP:           "add",
+	opcode.SUB_OP:           "sub",
+	opcode.MUL_OP:           "mul",
+	opcode.DIV_OP:           "div",
+	opcode.INC_OP:           "inc",
+	opcode.DEC_OP:           "dec",
+	opcode.AND_OP:           "and",
+	opcode.OR_OP:            "or",
+	opcode.SHL_OP:           "shl",
+	opcode.SHR_OP:           "shr",
+	opcode.STRING_STORE:     "store",
+	opcode.STRING_PRINT:     "print_str",
+	opcode.STRING_CONCAT:    "concat",
+	opcode.STRING_SYSTEM:    "system",
+	opcode.STRING_TOINT:     "string2int",
+	opcode.CMP_REG:          "cmp",
+	opcode.CMP_IMMEDIATE:    "cmp",
+	opcode.CMP_STRING:       "cmp",
+	opcode.IS_STRING:        "is_string",
+	opcode.IS_INTEGER:       "is_integer",
+	opcode.CMP_IMMEDIATE32:  "cmp",
+	opcode.CMP_IMMEDIATE64:  "cmp",
+	opcode.NOP_OP:           "nop",
+	opcode.REG_STORE:        "store",
+	opcode.PEEK:             "peek",
+	opcode.POKE:             "poke",
+	opcode.MEMCPY:           "memcpy",
+	opcode.STACK_PUSH:       "push",
+	opcode.STACK_POP:        "pop",
+	opcode.STACK_RET:        "ret",
+	opcode.STACK_CALL:       "call",
+	opcode.TRAP_OP:          "int",
+}
+
+// item is either a decoded instruction, or a single byte of data.
+type item struct {
+	addr int
+	in   *opcode.Instruction
+	data byte
+}
+
+// Disassemble converts the given bytecode into source, which may be
+// compiled for 16-bit words.
+func Disassemble(code []byte) string {
+	return DisassembleProgram(bytecode.New(code), 16)
+}
+
+// DisassembleProgram converts the given program into source, which may
+// be compiled for words of the given size.
+//
+// Labels are named by the program's symbol table, and its entry-point is
+// given via `.entry`, so that the source compiles to an identical
+// container.  Labels are only made up for programs without a symbol
+// table, as they'd otherwise be added to it.  Bare bytecode, which has
+// no symbol table to preserve, always compiles to identical bytecode.
+func DisassembleProgram(prog *bytecode.Program, wordSize int) string {
+	code := prog.Image()
+
+	// Every symbol, and the entry-point, must begin an instruction
+	// or byte of data, so that its label may be placed there.
+	names := make(map[int][]string)
+	used := make(map[string]bool)
+	for _, sym := range prog.Symbols {
+		if sym.Address <= len(code) {
+			names[sym.Address] = append(names[sym.Address], sym.Name)
+			used[sym.Name] = true
+		}
+	}
+	marks := make(map[int]bool)
+	for addr := range names {
+		marks[addr] = true
+	}
+	if prog.Entry != 0 {
+		marks[prog.Entry] = true
+	}
+
+	// Decode everything.
+	var items []item
+	addr := 0
+	for addr < len(code) {
+		in, err := opcode.Decode(code, addr)
+		if err != nil || !valid(in, wordSize) || spans(in, addr, marks) {
+			items = append(items, item{addr: addr, data: code[addr]})
+			addr++
+			continue
+		}
+		items = append(items, item{addr: addr, in: in})
+		addr += in.Length
+	}
+
+	// Find the destination of each jump/call, which we can label
+	// if it is the start of something we've decoded, or the end of
+	// the program.
+	starts := make(map[int]bool)
+	starts[len(code)] = true
+	for _, i := range items {
+		starts[i.addr] = true
+	}
+	label := func(addr int) {
+		if len(names[addr]) > 0 {
+			return
+		}
+		name := fmt.Sprintf("L_%04X", addr)
+		for used[name] {
+			name += "_"
+		}
+		names[addr] = []string{name}
+		used[name] = true
+	}
+	for _, i := range items {
+		if i.in == nil || len(prog.Symbols) > 0 {
+			continue
+		}
+		for n, op := range i.in.Operands {
+			addr := int(i.in.Args[n])
+			if op == opcode.Address && starts[addr] {
+				label(addr)
+			}
+		}
+	}
+	if prog.Entry != 0 {
+		label(prog.Entry)
+	}
+
+	// Operands refer to the first name of each address.
+	labels := make(map[int]string)
+	for addr, list := range names {
+		labels[addr] = list[0]
+	}
+
+	// Now generate the output.
+	var out strings.Builder
+	var data []byte
+
+	if prog.Entry != 0 {
+		out.WriteString(".entry " + labels[prog.Entry] + "\n")
+	}
+
+	// flush outputs any pending data.
+	flush := func() {
+		for len(data) > 0 {
+			n := len(data)
+			if n > 8 {
+				n = 8
+			}
+			vals := make([]string, n)
+			for i, b := range data[:n] {
+				vals[i] = fmt.Sprintf("0x%02X", b)
+			}
+			out.WriteString("        DB " + strings.Join(vals, ", ") + "\n")
+			data = data[n:]
+		}
+	}
+
+	for _, i := range items {
+		if len(names[i.addr]) > 0 {
+			flush()
+		}
+		for _, name := range names[i.addr] {
+			out.WriteString(":" + name + "\n")
+		}
+
+		if i.in == nil {
+			data = append(data, i.data)
+			continue
+		}
+
+		flush()
+		out.WriteString("        " + Format(i.in, labels) + "\n")
+	}
+	flush()
+
+	for _, name := range names[len(code)] {
+		out.WriteString(":" + name + "\n")
+	}
+
+	return out.String()
+}
+
+// spans returns true if the decoded instruction, at the given address,
+// covers any of the given addresses other than its first.
+func spans(in *opcode.Instruction, addr int, marks map[int]bool) bool {
+	for i := addr + 1; i < addr+in.Length; i++ {
+		if marks[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// valid returns true if the decoded instruction can be represented in
+// our source-form, and compiled for words of the given size.
+func valid(in *opcode.Instruction, wordSize int) bool {
+	for n, op := range in.Operands {
+		if op == opcode.Register && in.Args[n] > maxRegister {
+			return false
+		}
+
+		// The compiler uses the shortest encoding which holds a
+		// number in a word, so we can't reproduce a longer one.
+		// Words of 16 bits only use two bytes, and of 32 bits four.
+		// Negative eight-byte numbers are shown as large unsigned
+		// ones, which need all eight bytes.
+		val := in.Args[n]
+		if op == opcode.Number32 && (wordSize < 32 || val >= 0 && val <= 0xFFFF) {
+			return false
+		}
+		if op == opcode.Number64 && (wordSize < 64 || val >= 0 && val <= math.MaxInt32) {
+			return false
+		}
+	}
+	for _, b := range in.Data {
+		if !printable(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// printable returns true if the given byte may be used in a string.
+func printable(b byte) bool {
+	return (b >= 0x20 && b < 0x7F) || b == '\n' || b == '\r' || b == '\t'
+}
+
+// Format converts the given instruction to source, using the names in
+// the labels map for any addresses it refers to.
+func Format(in *opcode.Instruction, labels map[int]string) string {
+	var args []string
+	for n, op := range in.Operands {
+		val := in.Args[n]
+
+		switch op {
+		case opcode.Register:
+			args = append(args, fmt.Sprintf("#%d", val))
+		case opcode.Number:
+			args = append(args, fmt.Sprintf("0x%04X", val))
+		case opcode.Number32:
+			if val < 0 {
+				args = append(args, fmt.Sprintf("%d", val))
+			} else {
+				args = append(args, fmt.Sprintf("0x%08X", val))
+			}
+		case opcode.Number64:
+			args = append(args, fmt.Sprintf("0x%016X", uint64(val)))
+		case opcode.Address:
+			if name, ok := labels[int(val)]; ok {
+				args = append(args, name)
+			} else {
+				args = append(args, fmt.Sprintf("0x%04X", val))
+			}
+		case opcode.String:
+			args = append(args, quote(in.Data))
+		}
+	}
+
+	if len(args) == 0 {
+		return mnemonics[int(in.Opcode)]
+	}
+	return mnemonics[int(in.Opcode)] + " " + strings.Join(args, ", ")
+}
+
+// quote converts the given string to the form our lexer accepts.
+func quote(data []byte) string {
+	var out strings.Builder
+	out.WriteString("\"")
+	for _, b := range data {
+		switch b {
+		case '\n':
+			out.WriteString("\\n")
+		case '\r':
+			out.WriteString("\\r")
+		case '\t':
+			out.WriteString("\\t")
+		case '"':
+			out.WriteString("\\\"")
+		case '\\':
+			out.WriteString("\\\\")
+		default:
+			out.WriteByte(b)
+		}
+	}
+	out.WriteString("\"")
+	return out.String()
+}
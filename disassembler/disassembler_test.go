@@ -0,0 +1,240 @@
+package disassembler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
+)
+
+// build compiles the given source for words of the given size, as the
+// `compile` command does.
+func build(t *testing.T, src string, size int) *bytecode.Program {
+	t.Helper()
+
+	c := compiler.New(lexer.New(src))
+	c.SetWordSize(size)
+	err := c.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile:\n%s\n%s", src, err)
+	}
+	return c.Program()
+}
+
+// assemble compiles the given source, returning the bytecode.
+func assemble(t *testing.T, src string) []byte {
+	t.Helper()
+	return build(t, src, 16).Image()
+}
+
+// roundTrip ensures disassembling the given container, then compiling
+// the source for words of the given size, results in an identical
+// container.
+func roundTrip(t *testing.T, data []byte, size int) {
+	t.Helper()
+
+	prog, err := bytecode.Decode(data)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	src := DisassembleProgram(prog, size)
+	out := build(t, src, size).Encode()
+	if !bytes.Equal(data, out) {
+		t.Fatalf("round-trip failed\ninput: % X\noutput: % X\nsource:\n%s", data, out, src)
+	}
+}
+
+// roundTripCode ensures disassembling, then compiling, the given bytecode
+// results in identical bytecode, and that the container written for it,
+// including the labels we made up, survives a round-trip too.
+func roundTripCode(t *testing.T, code []byte) {
+	t.Helper()
+
+	src := Disassemble(code)
+	prog := build(t, src, 16)
+	if !bytes.Equal(code, prog.Image()) {
+		t.Fatalf("round-trip failed\ninput: % X\noutput: % X\nsource:\n%s", code, prog.Image(), src)
+	}
+	roundTrip(t, prog.Encode(), 16)
+}
+
+// TestDisassemble tests the output of a simple program.
+func TestDisassemble(t *testing.T) {
+	code := assemble(t, `
+:start
+        store #1, "Hello\n"
+        print_str #1
+        store #2, 0x10
+        cmp #2, #1
+        jmpz start
+        call 0x4000
+        DB 0xFF, 0x01
+        exit
+`)
+
+	expected := `:L_0000
+        store #1, "Hello\n"
+        print_str #1
+        store #2, 0x0010
+        cmp #2, #1
+        jmpz L_0000
+        call 0x4000
+        DB 0xFF, 0x01
+        exit
+`
+	out := Disassemble(code)
+	if out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+// TestSymbols tests that the names of labels, and the entry-point, are
+// taken from the program.
+func TestSymbols(t *testing.T) {
+	prog := build(t, `
+.entry start
+        store #1, 1
+:start
+        call print
+        jmp 0x0000
+:print
+:show
+        print_int #1
+        ret
+:unused
+`, 16)
+
+	expected := `.entry start
+        store #1, 0x0001
+:start
+        call print
+        jmp 0x0000
+:print
+:show
+        print_int #1
+        ret
+:unused
+`
+	out := DisassembleProgram(prog, 16)
+	if out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	roundTrip(t, prog.Encode(), 16)
+
+	// A symbol within an instruction forces it to be data.
+	prog.AddSymbol("middle", 5)
+	out = DisassembleProgram(prog, 16)
+	if !strings.Contains(out, "        DB 0x73\n:middle\n") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+// TestWide tests the output of four- and eight-byte numbers.
+func TestWide(t *testing.T) {
+	prog := build(t, `
+        store #1, 0x10000
+        store #1, -5
+        cmp #1, 0xCBF29CE484222325
+        cmp #1, 0xFFFFFFFFFFFFFFFF
+`, 64)
+
+	expected := `        store #1, 0x00010000
+        store #1, -5
+        cmp #1, 0xCBF29CE484222325
+        cmp #1, 0xFFFFFFFFFFFFFFFF
+`
+	out := DisassembleProgram(prog, 64)
+	if out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	roundTrip(t, prog.Encode(), 64)
+
+	// Narrower words can't hold them.
+	out = DisassembleProgram(prog, 32)
+	if !strings.HasPrefix(out, "        store #1, 0x00010000\n        store #1, -5\n        DB ") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if !strings.HasPrefix(Disassemble(prog.Image()), "        DB ") {
+		t.Fatalf("expected data, got %s", Disassemble(prog.Image()))
+	}
+}
+
+// TestMnemonics ensures every opcode we can decode has a mnemonic.
+func TestMnemonics(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		if _, ok := opcode.Operands(byte(i)); !ok {
+			continue
+		}
+		if _, ok := mnemonics[i]; !ok {
+			t.Errorf("opcode %02X has no mnemonic", i)
+		}
+	}
+}
+
+// TestUndecodable ensures that things we can't decode become data.
+func TestUndecodable(t *testing.T) {
+	tests := [][]byte{
+		// unknown opcode
+		{0xFE},
+		// truncated instruction
+		{byte(opcode.INT_STORE), 0x01, 0x02},
+		// register out of range
+		{byte(opcode.INC_OP), 0x20},
+		// string with binary data
+		{byte(opcode.STRING_STORE), 0x01, 0x02, 0x00, 0x00, 0xFF},
+		// numbers with a longer encoding than necessary
+		{byte(opcode.INT_STORE32), 0x01, 0x02, 0x00, 0x00, 0x00},
+		{byte(opcode.CMP_IMMEDIATE64), 0x01, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}
+	for _, code := range tests {
+		out := Disassemble(code)
+		if !strings.HasPrefix(out, "        DB ") {
+			t.Errorf("expected data, got %s", out)
+		}
+		roundTripCode(t, code)
+	}
+}
+
+// TestExamples ensures all our examples survive a round-trip.
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.in")
+	if err != nil {
+		t.Fatalf("failed to find examples: %s", err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", file, err)
+		}
+		size := 16
+		if filepath.Base(file) == "hash.in" {
+			size = 64
+		}
+		roundTrip(t, build(t, string(src), size).Encode(), size)
+	}
+}
+
+// TestRandom ensures random bytecode survives a round-trip.
+func TestRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		code := make([]byte, r.Intn(64))
+		for n := range code {
+			// Bias towards valid opcodes and registers
+			if r.Intn(2) == 0 {
+				code[n] = byte(r.Intn(16))
+			} else {
+				code[n] = byte(r.Intn(256))
+			}
+		}
+		roundTripCode(t, code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skx/go.vm/cpu"
+)
+
+//
+// pathList is a flag which may be given multiple times, collecting
+// each value it is given.
+//
+type pathList []string
+
+// String returns the values we've been given.
+func (p *pathList) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set records another value.
+func (p *pathList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+//
+// limitFlags holds the flags which limit the resources a program may
+// consume, which are shared by the sub-commands which execute programs.
+//
+type limitFlags struct {
+	// The maximum number of instructions to execute.
+	maxSteps int
+
+	// Limits upon the stack, strings, output, and input.
+	limits cpu.Limits
+
+	// Should the number of steps consumed always be reported?
+	verbose bool
+}
+
+// register adds our flags to the given set.
+func (l *limitFlags) register(f *flag.FlagSet) {
+	f.IntVar(&l.maxSteps, "max-steps", 0, "The maximum number of instructions to execute, zero for no limit.")
+	f.IntVar(&l.limits.StackDepth, "max-stack", 0, "The maximum depth of the stack, zero for no limit.")
+	f.IntVar(&l.limits.StringLength, "max-string", 0, "The maximum length of a string, zero for no limit.")
+	f.IntVar(&l.limits.Output, "max-output", 0, "The maximum number of bytes to write to STDOUT, zero for no limit.")
+	f.IntVar(&l.limits.Input, "max-input", 0, "The maximum number of bytes to read from STDIN, zero for no limit.")
+	f.BoolVar(&l.verbose, "verbose", false, "Report the number of steps the program consumed.")
+}
+
+// apply configures the given CPU with our limits.
+func (l *limitFlags) apply(c *cpu.CPU) {
+	c.SetMaxSteps(l.maxSteps)
+	c.SetLimits(l.limits)
+}
+
+// report shows the number of steps the program consumed, once it has
+// stopped, if it exhausted its budget or we're verbose.
+func (l *limitFlags) report(c *cpu.CPU, err error) {
+	if l.verbose || errors.Is(err, cpu.ErrBudgetExhausted) {
+		fmt.Fprintf(os.Stderr, "Executed %d steps\n", c.Steps())
+	}
+}
+
+//
+// modeFlags holds the flags which select how the machine behaves, which
+// are shared by the sub-commands which execute programs.
+//
+type modeFlags struct {
+	// Should arithmetic saturate, rather than wrapping around?
+	saturate bool
+
+	// Are integers signed?
+	signed bool
+
+	// The number of bits in our integers.
+	wordSize wordSize
+}
+
+// register adds our flags to the given set.
+func (m *modeFlags) register(f *flag.FlagSet) {
+	m.wordSize = 16
+	f.BoolVar(&m.saturate, "saturate", false, "Clamp arithmetic results to the range of a word, rather than wrapping around, as earlier releases did.")
+	f.BoolVar(&m.signed, "signed", false, "Treat integers as signed values.")
+	f.Var(&m.wordSize, "word-size", "The number of bits in our integers: 16, 32, or 64.")
+}
+
+// apply configures the given CPU with our modes.
+func (m *modeFlags) apply(c *cpu.CPU) {
+	c.SetSaturating(m.saturate)
+	c.SetSigned(m.signed)
+
+	// Our flag has already ensured the size is valid.
+	c.SetWordSize(int(m.wordSize))
+}
+
+//
+// wordSize is a flag holding the size of a word, which must be one that
+// the CPU supports.
+//
+type wordSize int
+
+// String returns the size we've been given.
+func (w *wordSize) String() string {
+	return strconv.Itoa(int(*w))
+}
+
+// Set records the size, if it is valid.
+func (w *wordSize) Set(value string) error {
+	switch value {
+	case "16", "32", "64":
+		n, _ := strconv.Atoi(value)
+		*w = wordSize(n)
+		return nil
+	}
+	return fmt.Errorf("expected 16, 32, or 64")
+}
+
+//
+// inputFlags holds the flags which control the random numbers, and
+// input, a program receives - allowing them to be seeded, recorded, or
+// replayed.  They are shared by the sub-commands which execute programs.
+//
+type inputFlags struct {
+	// The seed for random numbers, if one was given.
+	seed seedValue
+
+	// File to record the inputs to.
+	record string
+
+	// File to replay the inputs from.
+	replay string
+
+	// The recording, while it is open.
+	file *os.File
+}
+
+// register adds our flags to the given set.
+func (r *inputFlags) register(f *flag.FlagSet) {
+	f.Var(&r.seed, "seed", "Seed the random numbers with the given value, rather than from the time.")
+	f.StringVar(&r.record, "record", "", "Record the random numbers, and input, the program receives to the given file.")
+	f.StringVar(&r.replay, "replay", "", "Replay the random numbers, and input, recorded in the given file.")
+}
+
+// start configures the given CPU to seed, record, or replay, its inputs.
+func (r *inputFlags) start(c *cpu.CPU) error {
+	if r.seed.set {
+		c.SetSeed(r.seed.value)
+	}
+
+	if r.replay != "" {
+		file, err := os.Open(r.replay)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = c.Replay(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s - %s", r.replay, err.Error())
+		}
+	}
+
+	if r.record != "" {
+		file, err := os.Create(r.record)
+		if err != nil {
+			return err
+		}
+		r.file = file
+		c.Record(file)
+	}
+	return nil
+}
+
+// finish closes the recording, if any, and ensures that a program which
+// exited consumed every input which was replayed.
+func (r *inputFlags) finish(c *cpu.CPU) error {
+	if r.file != nil {
+		err := r.file.Close()
+		r.file = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.replay != "" && c.Halted() && c.ReplayRemaining() > 0 {
+		return fmt.Errorf("replay diverged: %d recorded inputs were never used", c.ReplayRemaining())
+	}
+	return nil
+}
+
+//
+// seedValue is a flag holding a seed for random numbers, which records
+// whether it was given - as any value, including zero, is a valid seed.
+//
+type seedValue struct {
+	value int64
+	set   bool
+}
+
+// String returns the seed we've been given.
+func (s *seedValue) String() string {
+	return strconv.FormatInt(s.value, 10)
+}
+
+// Set records the seed.
+func (s *seedValue) Set(value string) error {
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return err
+	}
+	s.value = n
+	s.set = true
+	return nil
+}
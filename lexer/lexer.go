@@ -2,6 +2,8 @@
 package lexer
 
 import (
+	"unicode/utf8"
+
 	"github.com/skx/go.vm/token"
 )
 
@@ -11,17 +13,38 @@ type Lexer struct {
 	readPosition int    //next character position
 	ch           rune   //current character
 	characters   []rune //rune slice of input string
+	line         int    //line of the current character
+	column       int    //column of the current character
+	offset       int    //byte offset of the current character
 }
 
 // New a Lexer instance from string input.
 func New(input string) *Lexer {
-	l := &Lexer{characters: []rune(input)}
+	l := &Lexer{characters: []rune(input), line: 1, column: 1}
 	l.readChar()
 	return l
 }
 
+// Input returns the text the lexer was created with.
+func (l *Lexer) Input() string {
+	return string(l.characters)
+}
+
 // read one forward character
 func (l *Lexer) readChar() {
+
+	// Update our location to account for the character we're
+	// moving past.
+	if l.readPosition > 0 && l.position < len(l.characters) {
+		if l.characters[l.position] == rune('\n') {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+		l.offset += utf8.RuneLen(l.characters[l.position])
+	}
+
 	if l.readPosition >= len(l.characters) {
 		l.ch = rune(0)
 	} else {
@@ -33,7 +56,6 @@ func (l *Lexer) readChar() {
 
 // NextToken to read next token, skipping the white space.
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
 	l.skipWhitespace()
 
 	//
@@ -41,19 +63,46 @@ func (l *Lexer) NextToken() token.Token {
 	// Unless they are immediately followed by a number, because
 	// our registers are "#N".
 	//
-	if l.ch == rune('#') {
-		if !isDigit(l.peekChar()) {
-			l.skipComment()
-			return (l.NextToken())
-		}
+	for l.ch == rune('#') && !isDigit(l.peekChar()) {
+		l.skipComment()
 	}
 
+	// Record where this token starts.
+	pos := token.Position{Line: l.line, Column: l.column, Offset: l.offset}
+
+	tok := l.readToken()
+	tok.Pos = pos
+	return tok
+}
+
+// readToken reads the token starting at the current character.
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case rune(','):
 		tok = newToken(token.COMMA, l.ch)
+	case rune('+'):
+		tok = newToken(token.PLUS, l.ch)
+	case rune('-'):
+		tok = newToken(token.MINUS, l.ch)
+	case rune('*'):
+		tok = newToken(token.ASTERISK, l.ch)
+	case rune('/'):
+		tok = newToken(token.SLASH, l.ch)
+	case rune('('):
+		tok = newToken(token.LPAREN, l.ch)
+	case rune(')'):
+		tok = newToken(token.RPAREN, l.ch)
 	case rune('"'):
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+		if isEmpty(l.ch) {
+			// We hit the end of our input before the
+			// terminating quote.
+			tok.Type = token.ILLEGAL
+			return tok
+		}
 	case rune(':'):
 		tok.Type = token.LABEL
 		tok.Literal = l.readLabel()
@@ -123,7 +172,7 @@ func (l *Lexer) readUntilWhitespace() string {
 // read decimal - this needs love to handle decimal and hex.
 func (l *Lexer) readDecimal() token.Token {
 	integer := l.readNumber()
-	if isEmpty(l.ch) || isWhitespace(l.ch) || l.ch == rune(',') {
+	if isEmpty(l.ch) || isWhitespace(l.ch) || l.ch == rune(',') || isOperator(l.ch) {
 		return token.Token{Type: token.INT, Literal: integer}
 	}
 
@@ -138,33 +187,32 @@ func (l *Lexer) readString() string {
 
 	for {
 		l.readChar()
-		if l.ch == '"' {
+		if l.ch == '"' || isEmpty(l.ch) {
 			break
 		}
 
 		//
 		// Handle \n, \r, \t, \", etc.
 		//
+		// Note that we don't modify `l.ch` as that would confuse
+		// our line-counting.
+		//
+		ch := l.ch
 		if l.ch == '\\' {
 			l.readChar()
+			ch = l.ch
 
 			if l.ch == rune('n') {
-				l.ch = '\n'
+				ch = '\n'
 			}
 			if l.ch == rune('r') {
-				l.ch = '\r'
+				ch = '\r'
 			}
 			if l.ch == rune('t') {
-				l.ch = '\t'
-			}
-			if l.ch == rune('"') {
-				l.ch = '"'
-			}
-			if l.ch == rune('\\') {
-				l.ch = '\\'
+				ch = '\t'
 			}
 		}
-		out = out + string(l.ch)
+		out = out + string(ch)
 	}
 
 	return out
@@ -183,7 +231,16 @@ func (l *Lexer) peekChar() rune {
 }
 
 func isIdentifier(ch rune) bool {
-	return ch != rune(',') && !isWhitespace(ch) && !isEmpty(ch)
+	return ch != rune(',') && !isOperator(ch) && !isWhitespace(ch) && !isEmpty(ch)
+}
+
+// is an operator, or parenthesis, used in expressions
+func isOperator(ch rune) bool {
+	switch ch {
+	case rune('+'), rune('-'), rune('*'), rune('/'), rune('('), rune(')'):
+		return true
+	}
+	return false
 }
 
 // is white space
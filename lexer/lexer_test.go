@@ -170,13 +170,17 @@ func TestSimpleJump(t *testing.T) {
 
 func TestReadDecimal(t *testing.T) {
 	input := `
-0123+3`
+0123+3
+0123q3`
 
 	tests := []struct {
 		expectedType    token.Type
 		expectedLiteral string
 	}{
-		{token.ILLEGAL, "0123+3"},
+		{token.INT, "0123"},
+		{token.PLUS, "+"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "0123q3"},
 		{token.EOF, ""},
 	}
 	l := New(input)
@@ -199,3 +203,49 @@ func TestReadDecimal(t *testing.T) {
 		i++
 	}
 }
+
+func TestPositions(t *testing.T) {
+	input := `# comment
+  store #1, "a\nb"
+:label
+	exit # done
+世界 nop`
+
+	tests := []struct {
+		expectedType token.Type
+		line         int
+		column       int
+		offset       int
+	}{
+		{token.STORE, 2, 3, 12},
+		{token.IDENT, 2, 9, 18},
+		{token.COMMA, 2, 11, 20},
+		{token.STRING, 2, 13, 22},
+		{token.LABEL, 3, 1, 29},
+		{token.EXIT, 4, 2, 37},
+		{token.IDENT, 5, 1, 49},
+		{token.NOP, 5, 4, 56},
+		{token.EOF, 5, 7, 59},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column || tok.Pos.Offset != tt.offset {
+			t.Fatalf("tests[%d] - position wrong, expected=%d:%d@%d, got=%d:%d@%d", i, tt.line, tt.column, tt.offset, tok.Pos.Line, tok.Pos.Column, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New(`store #1, "steve`)
+
+	for _, expected := range []token.Type{token.STORE, token.IDENT, token.COMMA, token.ILLEGAL, token.EOF} {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tokentype wrong, expected=%q, got=%q", expected, tok.Type)
+		}
+	}
+}
@@ -16,9 +16,13 @@ func main() {
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&compileCmd{}, "")
+	subcommands.Register(&debugCmd{}, "")
+	subcommands.Register(&disassembleCmd{}, "")
 	subcommands.Register(&dumpCmd{}, "")
 	subcommands.Register(&executeCmd{}, "")
+	subcommands.Register(&linkCmd{}, "")
 	subcommands.Register(&runCmd{}, "")
+	subcommands.Register(&verifyCmd{}, "")
 	subcommands.Register(&versionCmd{}, "")
 
 	flag.Parse()
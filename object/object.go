@@ -0,0 +1,176 @@
+// Package object contains relocatable object files, and the linker which
+// combines them into a single program.
+//
+// An object file holds the bytecode generated for a single source file,
+// before it has been given its final address.  Alongside the bytecode
+// are the symbols the object exports to others, the symbols it imports
+// from others, and a list of relocations - places in the bytecode which
+// must be patched with an address once that is known.
+//
+// Object files are stored as JSON, and conventionally have the suffix
+// `.obj`.
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Symbol is a label which an object exports to others.
+type Symbol struct {
+	// Name is the name of the label.
+	Name string `json:"name"`
+
+	// Address is the offset of the label within the object's code.
+	Address int `json:"address"`
+}
+
+// Relocation describes a value within the code which depends upon the
+// address of a symbol.
+type Relocation struct {
+	// Offset is the location of the value within the object's code.
+	Offset int `json:"offset"`
+
+	// Size is the number of bytes in the value, one or two.
+	Size int `json:"size"`
+
+	// Symbol is the name of the imported symbol the value refers to.
+	//
+	// If it is empty the value refers to a location within the same
+	// object, and Addend holds that location.
+	Symbol string `json:"symbol,omitempty"`
+
+	// Addend is added to the address of the symbol, or the address at
+	// which the object is placed, to give the value.
+	Addend int `json:"addend"`
+}
+
+// Object holds the contents of a single object file.
+type Object struct {
+	// Name is the name the object was loaded from, which is used when
+	// reporting problems.
+	Name string `json:"-"`
+
+	// Code is the bytecode of the object.
+	Code []byte `json:"code"`
+
+	// Exports lists the symbols this object makes available.
+	Exports []Symbol `json:"exports"`
+
+	// Imports lists the symbols this object requires from others.
+	Imports []string `json:"imports"`
+
+	// Relocations lists the values which must be patched once the
+	// address of the object, and its imports, are known.
+	Relocations []Relocation `json:"relocations"`
+}
+
+// Load reads an object from the named file.
+func Load(path string) (*Object, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{}
+	err = json.Unmarshal(data, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse object file %s - %s", path, err.Error())
+	}
+	obj.Name = path
+	return obj, nil
+}
+
+// Save writes the object to the named file.
+func (o *Object) Save(path string) error {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Link combines the given objects into a single program.
+//
+// The objects are placed one after another, in the order given, so the
+// first object contains the entry-point of the program.  Every problem
+// found, such as a symbol which is undefined or defined more than once,
+// is reported in the returned error.
+func Link(objects []*Object) ([]byte, error) {
+	var problems []string
+
+	// Decide where each object lives, and find the address of every
+	// exported symbol.
+	var code []byte
+	bases := make([]int, len(objects))
+	symbols := make(map[string]int)
+	owners := make(map[string]string)
+
+	for i, obj := range objects {
+		bases[i] = len(code)
+		code = append(code, obj.Code...)
+
+		for _, sym := range obj.Exports {
+			if owner, ok := owners[sym.Name]; ok {
+				problems = append(problems, fmt.Sprintf("duplicate symbol '%s' defined in %s and %s", sym.Name, owner, obj.Name))
+				continue
+			}
+			symbols[sym.Name] = bases[i] + sym.Address
+			owners[sym.Name] = obj.Name
+		}
+	}
+
+	// Ensure every import may be found.
+	for _, obj := range objects {
+		imports := append([]string{}, obj.Imports...)
+		sort.Strings(imports)
+		for _, name := range imports {
+			if _, ok := symbols[name]; !ok {
+				problems = append(problems, fmt.Sprintf("unresolved symbol '%s' used by %s", name, obj.Name))
+			}
+		}
+	}
+
+	// Now patch each relocation.
+	for i, obj := range objects {
+		for _, r := range obj.Relocations {
+			value := r.Addend
+			if r.Symbol == "" {
+				value += bases[i]
+			} else {
+				addr, ok := symbols[r.Symbol]
+				if !ok {
+					continue
+				}
+				value += addr
+			}
+
+			max := 0xFFFF
+			if r.Size == 1 {
+				max = 0xFF
+			}
+			if r.Size != 1 && r.Size != 2 || r.Offset < 0 || r.Offset+r.Size > len(obj.Code) {
+				problems = append(problems, fmt.Sprintf("invalid relocation at offset %d in %s", r.Offset, obj.Name))
+				continue
+			}
+			if value < 0 || value > max {
+				problems = append(problems, fmt.Sprintf("value %d at offset %d in %s is outside the range 0..%d", value, r.Offset, obj.Name, max))
+				continue
+			}
+
+			addr := bases[i] + r.Offset
+			code[addr] = byte(value % 256)
+			if r.Size == 2 {
+				code[addr+1] = byte(value / 256)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return code, nil
+}
@@ -0,0 +1,107 @@
+package object
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLink ensures objects are placed in order, with their relocations
+// patched.
+func TestLink(t *testing.T) {
+	main := &Object{
+		Name:    "main.obj",
+		Code:    []byte{0x10, 0x00, 0x00, 0x10, 0x00, 0x00},
+		Imports: []string{"lib"},
+		Relocations: []Relocation{
+			{Offset: 1, Size: 2, Symbol: "lib", Addend: 1},
+			{Offset: 4, Size: 2, Addend: 3},
+		},
+	}
+	lib := &Object{
+		Name:    "lib.obj",
+		Code:    []byte{0x50, 0x50, 0x00},
+		Exports: []Symbol{{Name: "lib", Address: 1}},
+		Relocations: []Relocation{
+			{Offset: 2, Size: 1, Addend: 0},
+		},
+	}
+
+	code, err := Link([]*Object{main, lib})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []byte{0x10, 0x08, 0x00, 0x10, 0x03, 0x00, 0x50, 0x50, 0x06}
+	if !reflect.DeepEqual(code, expected) {
+		t.Fatalf("unexpected code:\n% X\n% X", code, expected)
+	}
+}
+
+// TestLinkErrors ensures every problem is reported.
+func TestLinkErrors(t *testing.T) {
+	a := &Object{
+		Name:        "a.obj",
+		Code:        []byte{0x00, 0x00},
+		Exports:     []Symbol{{Name: "dup"}},
+		Imports:     []string{"missing"},
+		Relocations: []Relocation{{Offset: 0, Size: 2, Symbol: "missing"}},
+	}
+	b := &Object{
+		Name:        "b.obj",
+		Code:        []byte{0x00, 0x00},
+		Exports:     []Symbol{{Name: "dup"}},
+		Relocations: []Relocation{{Offset: 1, Size: 1, Addend: 0xFF}, {Offset: 1, Size: 2}},
+	}
+
+	_, err := Link([]*Object{a, b})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	for _, msg := range []string{
+		"duplicate symbol 'dup' defined in a.obj and b.obj",
+		"unresolved symbol 'missing' used by a.obj",
+		"value 257 at offset 1 in b.obj is outside the range 0..255",
+		"invalid relocation at offset 1 in b.obj",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected '%s' in:\n%s", msg, err)
+		}
+	}
+}
+
+// TestSaveLoad ensures objects survive a round-trip to disk.
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "object")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	obj := &Object{
+		Code:        []byte{1, 2, 3},
+		Exports:     []Symbol{{Name: "a", Address: 2}},
+		Imports:     []string{"b"},
+		Relocations: []Relocation{{Offset: 0, Size: 2, Symbol: "b", Addend: 4}},
+	}
+	path := filepath.Join(dir, "test.obj")
+	err = obj.Save(path)
+	if err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if loaded.Name != path {
+		t.Fatalf("name not recorded: %s", loaded.Name)
+	}
+	loaded.Name = ""
+	if !reflect.DeepEqual(obj, loaded) {
+		t.Fatalf("round-trip failed:\n%v\n%v", obj, loaded)
+	}
+}
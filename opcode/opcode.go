@@ -1,6 +1,11 @@
 // Package opcode defines our opcode to integer mapping.
+//
+// It also records the arguments each instruction expects, which allows
+// bytecode to be decoded without executing it.
 package opcode
 
+import "fmt"
+
 var (
 	// EXIT is our first opcode.
 	EXIT = 0x00
@@ -17,6 +22,15 @@ var (
 	// INT_RANDOM generates a random number.
 	INT_RANDOM = 0x04
 
+	// INT_RANDOM_RANGE generates a random number within a range.
+	INT_RANDOM_RANGE = 0x05
+
+	// INT_STORE32 stores an integer, encoded in four bytes, in a register.
+	INT_STORE32 = 0x06
+
+	// INT_STORE64 stores an integer, encoded in eight bytes, in a register.
+	INT_STORE64 = 0x07
+
 	// JUMP_TO is an unconditional jump.
 	JUMP_TO = 0x10
 
@@ -26,6 +40,36 @@ var (
 	// JUMP_NZ jumps if the Z-flag is NOT set.
 	JUMP_NZ = 0x12
 
+	// JUMP_C jumps if the C-flag is set.
+	JUMP_C = 0x13
+
+	// JUMP_NC jumps if the C-flag is NOT set.
+	JUMP_NC = 0x14
+
+	// JUMP_O jumps if the O-flag is set.
+	JUMP_O = 0x15
+
+	// JUMP_NO jumps if the O-flag is NOT set.
+	JUMP_NO = 0x16
+
+	// JUMP_N jumps if the S-flag is set, i.e. the result was negative.
+	JUMP_N = 0x17
+
+	// JUMP_NN jumps if the S-flag is NOT set.
+	JUMP_NN = 0x18
+
+	// JUMP_LT jumps if the last comparison found less-than.
+	JUMP_LT = 0x19
+
+	// JUMP_LE jumps if the last comparison found less-than, or equal.
+	JUMP_LE = 0x1A
+
+	// JUMP_GT jumps if the last comparison found greater-than.
+	JUMP_GT = 0x1B
+
+	// JUMP_GE jumps if the last comparison found greater-than, or equal.
+	JUMP_GE = 0x1C
+
 	// XOR_OP performs an XOR operation against two registers.
 	XOR_OP = 0x20
 
@@ -53,6 +97,12 @@ var (
 	// OR_OP performs a logical OR operation against two registers.
 	OR_OP = 0x28
 
+	// SHL_OP shifts the contents of a register left.
+	SHL_OP = 0x29
+
+	// SHR_OP shifts the contents of a register right.
+	SHR_OP = 0x2A
+
 	// STRING_STORE stores a string in a register.
 	STRING_STORE = 0x30
 
@@ -83,6 +133,12 @@ var (
 	// IS_INTEGER tests if a register contains an integer.
 	IS_INTEGER = 0x44
 
+	// CMP_IMMEDIATE32 compares a register contents with a four-byte number.
+	CMP_IMMEDIATE32 = 0x45
+
+	// CMP_IMMEDIATE64 compares a register contents with an eight-byte number.
+	CMP_IMMEDIATE64 = 0x46
+
 	// NOP_OP does nothing.
 	NOP_OP = 0x50
 
@@ -114,6 +170,191 @@ var (
 	TRAP_OP = 0x80
 )
 
+// Operand describes the type of a single instruction argument.
+type Operand int
+
+const (
+	// Register arguments are a single byte, holding a register number.
+	Register Operand = iota
+
+	// Number arguments are a two-byte little-endian integer.
+	Number
+
+	// Address arguments are a two-byte little-endian address, used
+	// as the destination of a jump or call.
+	Address
+
+	// String arguments are a two-byte little-endian length, followed
+	// by that many bytes of data.
+	String
+
+	// Number32 arguments are a four-byte little-endian integer, in
+	// two's complement form.
+	Number32
+
+	// Number64 arguments are an eight-byte little-endian integer, in
+	// two's complement form.
+	Number64
+)
+
+// operands holds the arguments each of our instructions expect.
+var operands = map[int][]Operand{
+	EXIT:             {},
+	INT_STORE:        {Register, Number},
+	INT_PRINT:        {Register},
+	INT_TOSTRING:     {Register},
+	INT_RANDOM:       {Register},
+	INT_RANDOM_RANGE: {Register, Register, Register},
+	INT_STORE32:      {Register, Number32},
+	INT_STORE64:      {Register, Number64},
+	JUMP_TO:          {Address},
+	JUMP_Z:           {Address},
+	JUMP_NZ:          {Address},
+	JUMP_C:           {Address},
+	JUMP_NC:          {Address},
+	JUMP_O:           {Address},
+	JUMP_NO:          {Address},
+	JUMP_N:           {Address},
+	JUMP_NN:          {Address},
+	JUMP_LT:          {Address},
+	JUMP_LE:          {Address},
+	JUMP_GT:          {Address},
+	JUMP_GE:          {Address},
+	XOR_OP:           {Register, Register, Register},
+	ADD_OP:           {Register, Register, Register},
+	SUB_OP:           {Register, Register, Register},
+	MUL_OP:           {Register, Register, Register},
+	DIV_OP:           {Register, Register, Register},
+	INC_OP:           {Register},
+	DEC_OP:           {Register},
+	AND_OP:           {Register, Register, Register},
+	OR_OP:            {Register, Register, Register},
+	SHL_OP:           {Register, Register, Register},
+	SHR_OP:           {Register, Register, Register},
+	STRING_STORE:     {Register, String},
+	STRING_PRINT:     {Register},
+	STRING_CONCAT:    {Register, Register, Register},
+	STRING_SYSTEM:    {Register},
+	STRING_TOINT:     {Register},
+	CMP_REG:          {Register, Register},
+	CMP_IMMEDIATE:    {Register, Number},
+	CMP_STRING:       {Register, String},
+	IS_STRING:        {Register},
+	IS_INTEGER:       {Register},
+	CMP_IMMEDIATE32:  {Register, Number32},
+	CMP_IMMEDIATE64:  {Register, Number64},
+	NOP_OP:           {},
+	REG_STORE:        {Register, Register},
+	PEEK:             {Register, Register},
+	POKE:             {Register, Register},
+	MEMCPY:           {Register, Register, Register},
+	STACK_PUSH:       {Register},
+	STACK_POP:        {Register},
+	STACK_RET:        {},
+	STACK_CALL:       {Address},
+	TRAP_OP:          {Number},
+}
+
+// Operands returns the types of the arguments the given instruction
+// expects, and false if the instruction is not recognized.
+func Operands(instruction byte) ([]Operand, bool) {
+	ops, ok := operands[int(instruction)]
+	return ops, ok
+}
+
+// Instruction holds a single instruction which has been decoded from
+// bytecode, along with its arguments.
+type Instruction struct {
+	// Address is the offset of the instruction within the bytecode.
+	Address int
+
+	// Opcode is the instruction itself.
+	Opcode byte
+
+	// Operands holds the types of the arguments.
+	Operands []Operand
+
+	// Args holds the value of each argument: register numbers,
+	// numbers, or addresses.  For strings this is the length.
+	// Four- and eight-byte numbers are sign-extended.
+	Args []int64
+
+	// Data holds the contents of a string argument, if any.
+	Data []byte
+
+	// Length is the number of bytes used by the instruction, and
+	// its arguments.
+	Length int
+}
+
+// Decode decodes the instruction found at the given address within
+// the bytecode.
+//
+// An error is returned if the instruction is not recognized, or if
+// its arguments extend beyond the end of the bytecode.
+func Decode(code []byte, addr int) (*Instruction, error) {
+	if addr < 0 || addr >= len(code) {
+		return nil, fmt.Errorf("address %04X is outside the bytecode", addr)
+	}
+
+	ops, ok := Operands(code[addr])
+	if !ok {
+		return nil, fmt.Errorf("unrecognized opcode %02X at %04X", code[addr], addr)
+	}
+
+	in := &Instruction{Address: addr, Opcode: code[addr], Operands: ops}
+	pos := addr + 1
+
+	for _, op := range ops {
+		switch op {
+		case Register:
+			if pos >= len(code) {
+				return nil, fmt.Errorf("truncated instruction at %04X", addr)
+			}
+			in.Args = append(in.Args, int64(code[pos]))
+			pos++
+
+		case Number, Address, String:
+			if pos+1 >= len(code) {
+				return nil, fmt.Errorf("truncated instruction at %04X", addr)
+			}
+			val := int(code[pos]) + int(code[pos+1])*256
+			in.Args = append(in.Args, int64(val))
+			pos += 2
+
+			if op == String {
+				if pos+val > len(code) {
+					return nil, fmt.Errorf("truncated string at %04X", addr)
+				}
+				in.Data = code[pos : pos+val]
+				pos += val
+			}
+
+		case Number32, Number64:
+			size := 4
+			if op == Number64 {
+				size = 8
+			}
+			if pos+size > len(code) {
+				return nil, fmt.Errorf("truncated instruction at %04X", addr)
+			}
+			var val uint64
+			for i := size - 1; i >= 0; i-- {
+				val = val<<8 | uint64(code[pos+i])
+			}
+			if op == Number32 {
+				in.Args = append(in.Args, int64(int32(val)))
+			} else {
+				in.Args = append(in.Args, int64(val))
+			}
+			pos += size
+		}
+	}
+
+	in.Length = pos - addr
+	return in, nil
+}
+
 // Opcode is a holder for a single instruction.
 // Note that this doesn't take any account of the arguments which might
 // be necessary.
@@ -142,12 +383,38 @@ func (o *Opcode) String() string {
 		return "INT_TOSTRING"
 	case INT_RANDOM:
 		return "INT_RANDOM"
+	case INT_RANDOM_RANGE:
+		return "INT_RANDOM_RANGE"
+	case INT_STORE32:
+		return "INT_STORE32"
+	case INT_STORE64:
+		return "INT_STORE64"
 	case JUMP_TO:
 		return "JUMP_TO"
 	case JUMP_Z:
 		return "JUMP_Z"
 	case JUMP_NZ:
 		return "JUMP_NZ"
+	case JUMP_C:
+		return "JUMP_C"
+	case JUMP_NC:
+		return "JUMP_NC"
+	case JUMP_O:
+		return "JUMP_O"
+	case JUMP_NO:
+		return "JUMP_NO"
+	case JUMP_N:
+		return "JUMP_N"
+	case JUMP_NN:
+		return "JUMP_NN"
+	case JUMP_LT:
+		return "JUMP_LT"
+	case JUMP_LE:
+		return "JUMP_LE"
+	case JUMP_GT:
+		return "JUMP_GT"
+	case JUMP_GE:
+		return "JUMP_GE"
 
 	case XOR_OP:
 		return "XOR_OP"
@@ -167,6 +434,10 @@ func (o *Opcode) String() string {
 		return "AND_OP"
 	case OR_OP:
 		return "OR_OP"
+	case SHL_OP:
+		return "SHL_OP"
+	case SHR_OP:
+		return "SHR_OP"
 	case STRING_STORE:
 		return "STRING_STORE"
 	case STRING_PRINT:
@@ -187,6 +458,10 @@ func (o *Opcode) String() string {
 		return "IS_STRING"
 	case IS_INTEGER:
 		return "IS_INTEGER"
+	case CMP_IMMEDIATE32:
+		return "CMP_IMMEDIATE32"
+	case CMP_IMMEDIATE64:
+		return "CMP_IMMEDIATE64"
 	case NOP_OP:
 		return "NOP"
 	case REG_STORE:
@@ -1,13 +1,33 @@
 // Package token contains the list of token-types we accept/recognize.
 package token
 
+import "fmt"
+
 // Type is a string
 type Type string
 
+// Position holds the location of a token within the source.
+type Position struct {
+	File   string // file name, if known
+	Line   int    // line number, starting at 1
+	Column int    // column number, in characters, starting at 1
+	Offset int    // byte offset, starting at 0
+}
+
+// String returns the position in the form "line:column", prefixed by
+// the file name if it is known.
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 // Token struct represent the lexer token
 type Token struct {
 	Type    Type
 	Literal string
+	Pos     Position
 }
 
 // pre-defined Type
@@ -20,6 +40,14 @@ const (
 	STRING  = "STRING"
 	COMMA   = "COMMA"
 
+	// expressions
+	PLUS     = "+"
+	MINUS    = "-"
+	ASTERISK = "*"
+	SLASH    = "/"
+	LPAREN   = "("
+	RPAREN   = ")"
+
 	// math
 	ADD = "ADD"
 	AND = "AND"
@@ -28,13 +56,25 @@ const (
 	INC = "INC"
 	MUL = "MUL"
 	OR  = "OR"
+	SHL = "SHL"
+	SHR = "SHR"
 	SUB = "SUB"
 	XOR = "XOR"
 
 	// control-flow
 	CALL  = "CALL"
 	JMP   = "JMP"
+	JMPC  = "JMPC"
+	JMPGE = "JMPGE"
+	JMPGT = "JMPGT"
+	JMPLE = "JMPLE"
+	JMPLT = "JMPLT"
+	JMPN  = "JMPN"
+	JMPNC = "JMPNC"
+	JMPNN = "JMPNN"
+	JMPNO = "JMPNO"
 	JMPNZ = "JMPNZ"
+	JMPO  = "JMPO"
 	JMPZ  = "JMPZ"
 	RET   = "RET"
 
@@ -62,6 +102,15 @@ const (
 	PEEK = "PEEK"
 	POKE = "POKE"
 
+	// directives
+	DEFINE  = "DEFINE"
+	ENTRY   = "ENTRY"
+	EQU     = "EQU"
+	GLOBAL  = "GLOBAL"
+	INCLUDE = "INCLUDE"
+	MACRO   = "MACRO"
+	ENDM    = "ENDM"
+
 	// Misc
 	CONCAT = "CONCAT"
 	DATA   = "DATA"
@@ -101,13 +150,25 @@ var keywords = map[string]Type{
 	"inc": INC,
 	"mul": MUL,
 	"or":  OR,
+	"shl": SHL,
+	"shr": SHR,
 	"sub": SUB,
 	"xor": XOR,
 
 	// control-flow
 	"call":  CALL,
 	"jmp":   JMP,
+	"jmpc":  JMPC,
+	"jmpge": JMPGE,
+	"jmpgt": JMPGT,
+	"jmple": JMPLE,
+	"jmplt": JMPLT,
+	"jmpn":  JMPN,
+	"jmpnc": JMPNC,
+	"jmpnn": JMPNN,
+	"jmpno": JMPNO,
 	"jmpnz": JMPNZ,
+	"jmpo":  JMPO,
 	"jmpz":  JMPZ,
 	"ret":   RET,
 
@@ -119,6 +180,15 @@ var keywords = map[string]Type{
 	"peek": PEEK,
 	"poke": POKE,
 
+	// directives
+	".define":  DEFINE,
+	".entry":   ENTRY,
+	".equ":     EQU,
+	".global":  GLOBAL,
+	".include": INCLUDE,
+	".macro":   MACRO,
+	".endm":    ENDM,
+
 	// misc
 	"exit":   EXIT,
 	"concat": CONCAT,
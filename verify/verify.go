@@ -0,0 +1,192 @@
+// Package verify contains a static verifier for our bytecode.
+//
+// The CPU only discovers problems, such as unknown opcodes or invalid
+// registers, when it reaches them - which might be after a program has
+// already produced output, or made other changes.  The verifier finds
+// these problems before a program is executed.
+//
+// Verification starts at the entry-point of a program and follows every
+// path through the code: falling through to the next instruction, and
+// following jumps and calls to their targets.  Code which can't be
+// reached isn't examined, as programs may freely mix code and data.
+//
+// Returning from a subroutine continues after the call which invoked it,
+// which we've already followed, so the verifier doesn't need to track
+// the stack.
+package verify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/go.vm/bytecode"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/opcode"
+)
+
+// Problem describes a single problem found within a program.
+type Problem struct {
+	// Address is the address of the instruction with the problem.
+	Address int
+
+	// Message describes the problem.
+	Message string
+}
+
+// String converts the problem to a string.
+func (p Problem) String() string {
+	return fmt.Sprintf("%04X: %s", p.Address, p.Message)
+}
+
+// Problems is a list of problems, which may be used as an error.
+type Problems []Problem
+
+// Error implements the error interface, returning each problem upon a
+// line of its own.
+func (p Problems) Error() string {
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Program verifies the given program.
+func Program(prog *bytecode.Program) error {
+	return Verify(prog.Image(), prog.Entry)
+}
+
+// Verify verifies the code, which is loaded at address zero, starting
+// from the given entry-point.
+//
+// If any problems are found they are returned as Problems.
+func Verify(code []byte, entry int) error {
+	v := &verifier{
+		code:    code,
+		decoded: make(map[int]*opcode.Instruction),
+		owner:   make(map[int]int),
+	}
+	if entry < 0 || entry > len(code) {
+		v.report(entry, "entry point is outside the program")
+	}
+	v.walk(entry)
+	v.checkTargets()
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Address < v.problems[j].Address
+	})
+	return v.problems
+}
+
+// verifier holds the state of a single verification.
+type verifier struct {
+	// code is the program being verified.
+	code []byte
+
+	// decoded holds every reachable instruction, by address.
+	decoded map[int]*opcode.Instruction
+
+	// owner records the address of the instruction each reachable
+	// byte belongs to.
+	owner map[int]int
+
+	// targets records the target of each jump and call, by the
+	// address of the instruction making it.
+	targets []target
+
+	// problems holds the problems we've found.
+	problems Problems
+}
+
+// target records a jump or call.
+type target struct {
+	from int
+	to   int
+}
+
+// report records a problem.
+func (v *verifier) report(addr int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Address: addr, Message: fmt.Sprintf(format, args...)})
+}
+
+// walk follows every path through the code from the given address.
+func (v *verifier) walk(entry int) {
+	pending := []int{entry}
+
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		// Falling off the end of the program is fine, as memory
+		// is zeroed, and zero is the `exit` instruction.  Targets
+		// beyond that are reported by checkTargets.
+		if addr >= len(v.code) {
+			continue
+		}
+		if _, seen := v.decoded[addr]; seen {
+			continue
+		}
+
+		in, err := opcode.Decode(v.code, addr)
+		if err != nil {
+			v.report(addr, "%s", err.Error())
+			continue
+		}
+		v.decoded[addr] = in
+
+		// Record the bytes this instruction covers, which must not be
+		// shared with any other.
+		for i := addr; i < addr+in.Length; i++ {
+			if other, ok := v.owner[i]; ok {
+				v.report(addr, "instruction overlaps the instruction at %04X", other)
+				break
+			}
+			v.owner[i] = addr
+		}
+
+		for n, op := range in.Operands {
+			if op == opcode.Register && in.Args[n] >= cpu.RegisterCount {
+				v.report(addr, "invalid register %d", in.Args[n])
+			}
+		}
+
+		// Find where execution may continue.
+		next := addr + in.Length
+		switch int(in.Opcode) {
+		case opcode.EXIT, opcode.STACK_RET:
+			// nothing follows
+		case opcode.JUMP_TO:
+			v.targets = append(v.targets, target{from: addr, to: int(in.Args[0])})
+			pending = append(pending, int(in.Args[0]))
+		default:
+			pending = append(pending, next)
+
+			// Conditional jumps, and calls, may continue at
+			// their target too.
+			for n, op := range in.Operands {
+				if op == opcode.Address {
+					v.targets = append(v.targets, target{from: addr, to: int(in.Args[n])})
+					pending = append(pending, int(in.Args[n]))
+				}
+			}
+		}
+	}
+}
+
+// checkTargets ensures each jump and call targets the start of an
+// instruction within the program.
+func (v *verifier) checkTargets() {
+	for _, t := range v.targets {
+		if t.to > len(v.code) {
+			v.report(t.from, "target %04X is outside the program", t.to)
+			continue
+		}
+		if start, ok := v.owner[t.to]; ok && start != t.to {
+			v.report(t.from, "target %04X is within the instruction at %04X", t.to, start)
+		}
+	}
+}
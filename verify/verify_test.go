@@ -0,0 +1,91 @@
+package verify
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
+)
+
+// TestExamples ensures each of our examples verifies.
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.in")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("failed to find examples: %v", err)
+	}
+
+	// These examples jump to code they write at runtime, outside
+	// the program, which can't be verified.
+	generated := map[string]bool{
+		"memcpy.in": true,
+		"poke.in":   true,
+	}
+
+	// These examples must be compiled for wider words.
+	wordSize := map[string]int{
+		"hash.in": 64,
+	}
+
+	for _, file := range files {
+		if generated[filepath.Base(file)] {
+			continue
+		}
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", file, err)
+		}
+		c := compiler.New(lexer.New(string(src)))
+		c.SetFilename(file)
+		if size, ok := wordSize[filepath.Base(file)]; ok {
+			c.SetWordSize(size)
+		}
+		if err := c.Compile(); err != nil {
+			t.Fatalf("failed to compile %s: %s", file, err)
+		}
+
+		err = Program(c.Program())
+		if err != nil {
+			t.Errorf("%s failed to verify: %s", file, err)
+		}
+	}
+}
+
+// TestProblems ensures bogus programs are rejected.
+func TestProblems(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		error string
+	}{
+		{"opcode", []byte{0xFE}, "0000: unrecognized opcode FE at 0000"},
+		{"register", []byte{byte(opcode.INC_OP), 15}, "0000: invalid register 15"},
+		{"truncated", []byte{byte(opcode.STRING_STORE), 1, 10, 0, 'a'}, "0000: truncated string at 0000"},
+		{"outside", []byte{byte(opcode.JUMP_TO), 0x00, 0x10}, "0000: target 1000 is outside the program"},
+		{"middle", []byte{byte(opcode.JUMP_Z), 0x01, 0x00}, "0000: target 0001 is within the instruction at 0000"},
+		{"call", []byte{byte(opcode.NOP_OP), byte(opcode.STACK_CALL), 0x02, 0x00}, "0001: target 0002 is within the instruction at 0001"},
+		{"reached", []byte{byte(opcode.JUMP_TO), 0x04, 0x00, 0xFE, byte(opcode.INC_OP), 99}, "0004: invalid register 99"},
+	}
+
+	for _, test := range tests {
+		err := Verify(test.code, 0)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected '%s', got '%v'", test.name, test.error, err)
+		}
+	}
+
+	// Unreachable data is ignored.
+	err := Verify([]byte{byte(opcode.JUMP_TO), 0x04, 0x00, 0xFE, byte(opcode.EXIT)}, 0)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// Jumping to the end of the program is fine.
+	err = Verify([]byte{byte(opcode.JUMP_TO), 0x03, 0x00}, 0)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}